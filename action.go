@@ -0,0 +1,397 @@
+package parser
+
+import (
+	"fmt"
+	"go/parser"
+	goscanner "go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// actionRef is a reference to a semantic value found in an action: $$, $N
+// or the bison forms $<field>$ and $<field>N. N may be 0 or negative to reach
+// values left of the rule, which needs an explicit field. Named references
+// $name and $[name] are resolved into the others once a rule is read. With
+// loc set the reference is to the span of the symbol instead, written @$,
+// @N, @name or @[name].
+type actionRef struct {
+	start, end int // byte range in the code
+	tag        string
+	lhs        bool
+	index      int
+	name       string
+	loc        bool
+}
+
+func (ref actionRef) String() string {
+	text := "$"
+	if ref.loc {
+		text = "@"
+	}
+	if len(ref.tag) > 0 {
+		text += "<" + ref.tag + ">"
+	}
+	switch {
+	case ref.lhs:
+		return text + "$"
+	case len(ref.name) > 0 && strings.IndexFunc(ref.name, func(r rune) bool { return r > 127 || !isIdentChar(byte(r)) }) >= 0:
+		return text + "[" + ref.name + "]"
+	case len(ref.name) > 0:
+		return text + ref.name
+	}
+	return text + strconv.Itoa(ref.index)
+}
+
+// findActionRefs lists the value and span references of code in order. A $
+// or @ in a string, rune or comment is left alone.
+func findActionRefs(code string) []actionRef {
+	refs := make([]actionRef, 0)
+	literals := goLiterals(code)
+	for i := 0; i < len(code); i++ {
+		for len(literals) > 0 && literals[0][1] <= i {
+			literals = literals[1:]
+		}
+		if len(literals) > 0 && literals[0][0] <= i {
+			i = literals[0][1] - 1
+			continue
+		}
+		if code[i] != '$' && code[i] != '@' {
+			continue
+		}
+		ref := actionRef{start: i, loc: code[i] == '@'}
+		j := i + 1
+		if j < len(code) && code[j] == '<' && !ref.loc {
+			k := j + 1
+			for k < len(code) && isIdentChar(code[k]) {
+				k++
+			}
+			if k == len(code) || code[k] != '>' {
+				continue
+			}
+			ref.tag = code[j+1 : k]
+			j = k + 1
+		}
+		if j < len(code) && code[j] == '$' {
+			ref.lhs = true
+			ref.end = j + 1
+		} else if j < len(code) && code[j] == '[' {
+			k := strings.IndexByte(code[j:], ']')
+			if k < 2 || !isRefName(code[j+1:j+k]) {
+				continue
+			}
+			ref.name = code[j+1 : j+k]
+			ref.end = j + k + 1
+		} else if j < len(code) && isIdentChar(code[j]) && (code[j] < '0' || code[j] > '9') {
+			k := j
+			for k < len(code) && isIdentChar(code[k]) {
+				k++
+			}
+			ref.name = code[j:k]
+			ref.end = k
+		} else {
+			k := j
+			if k < len(code) && code[k] == '-' {
+				k++
+			}
+			digits := k
+			for k < len(code) && code[k] >= '0' && code[k] <= '9' {
+				k++
+			}
+			if k == digits {
+				continue
+			}
+			ref.index, _ = strconv.Atoi(code[j:k])
+			ref.end = k
+		}
+		refs = append(refs, ref)
+		i = ref.end - 1
+	}
+	return refs
+}
+
+// goLiterals lists the byte ranges of the strings, runes and comments of
+// code, lexed as Go.
+func goLiterals(code string) [][2]int {
+	file := token.NewFileSet().AddFile("", -1, len(code))
+	var lexer goscanner.Scanner
+	lexer.Init(file, []byte(code), nil, goscanner.ScanComments)
+	spans := make([][2]int, 0)
+	for {
+		pos, tok, lit := lexer.Scan()
+		switch tok {
+		case token.EOF:
+			return spans
+		case token.STRING, token.CHAR, token.COMMENT:
+			start := file.Offset(pos)
+			spans = append(spans, [2]int{start, start + len(lit)})
+		}
+	}
+}
+
+// checkAction parses code as a Go block, its references standing for
+// variables, and reports the first syntax error where it is in the grammar.
+func checkAction(code string, pos Position) error {
+	const prefix = "package p\nfunc _() "
+	body := rewriteActionRefs(code, func(ref actionRef) string {
+		return "_" + strings.Repeat("v", ref.end-ref.start-1)
+	})
+	_, err := parser.ParseFile(token.NewFileSet(), "", prefix+body, 0)
+	list, b := err.(goscanner.ErrorList)
+	if !b || len(list) == 0 {
+		return err
+	}
+	at := list[0].Pos
+	offset := at.Offset - len(prefix)
+	if offset < 0 || offset > len(code) {
+		offset = len(code)
+	}
+	return &GrammarError{Position: codePosition(pos, code, offset), Msg: "invalid action: " + list[0].Msg}
+}
+
+// checkActions checks the actions of the rules and %defaultcode are Go and
+// that every value they read has a field.
+func (self *Grammar) checkActions() error {
+	if len(self.defaultcode) > 0 {
+		if err := checkAction(self.defaultcode, self.defaultPos); err != nil {
+			return err
+		}
+	}
+	for _, prod := range self.prods {
+		code, codePos := prod.code, prod.codePos
+		if len(code) == 0 {
+			code, codePos = self.defaultcode, self.defaultPos
+		} else if err := checkAction(code, codePos); err != nil {
+			return err
+		}
+		for _, ref := range findActionRefs(code) {
+			if msg := self.untypedRef(&prod, ref); len(msg) > 0 {
+				return &GrammarError{Position: codePosition(codePos, code, ref.start), Msg: msg}
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteActionRefs replaces every value reference of code with what
+// replace returns for it.
+func rewriteActionRefs(code string, replace func(ref actionRef) string) string {
+	text, last := "", 0
+	for _, ref := range findActionRefs(code) {
+		text += code[last:ref.start] + replace(ref)
+		last = ref.end
+	}
+	return text + code[last:]
+}
+
+// moveActionRefs renumbers the $N references of code with shift. A value
+// that ends up left of its rule is given the field of the symbol it came
+// from, as the new rule cannot tell its type any more.
+func (self *Grammar) moveActionRefs(code string, body []string, shift func(n int) int) string {
+	return rewriteActionRefs(code, func(ref actionRef) string {
+		if ref.lhs {
+			return ref.String()
+		}
+		moved := ref
+		moved.index = shift(ref.index)
+		if !ref.loc && len(moved.tag) == 0 && moved.index <= 0 && ref.index >= 1 && ref.index <= len(body) {
+			moved.tag = self.symbolType(body[ref.index-1])
+		}
+		return moved.String()
+	})
+}
+
+// symbolType returns the union field declared for a symbol.
+func (self *Grammar) symbolType(sym string) string {
+	if _, b := self.symbolSet[sym]; b {
+		return self.nontermTypes[sym]
+	}
+	return self.termTypes[sym]
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isRefName tells if name may be written as $[name] or Sym[name]: letters,
+// digits, '_', '.' and '-', not starting with a digit.
+func isRefName(name string) bool {
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) && name[i] != '.' && name[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveActionRefs turns the named references of the action of prod into
+// positional ones. A name is the rule's own name for $$, or the alias or
+// else the name of one body symbol. $N beyond the body is an error too.
+func (self *Grammar) resolveActionRefs(prod *Production) error {
+	var err error
+	prod.code = rewriteActionRefs(prod.code, func(ref actionRef) string {
+		if err != nil {
+			return ref.String()
+		}
+		pos := codePosition(prod.codePos, prod.code, ref.start)
+		if len(ref.name) == 0 {
+			if !ref.lhs && ref.index > len(prod.body) {
+				err = &GrammarError{Position: pos,
+					Msg: fmt.Sprintf("%s is out of range in rule %s, which has %d symbols", ref, prod.name, len(prod.body))}
+			}
+			return ref.String()
+		}
+		matches := make([]int, 0)
+		if ref.name == prod.name {
+			matches = append(matches, 0)
+		}
+		for i, sym := range prod.body {
+			alias := ""
+			if i < len(prod.aliases) {
+				alias = prod.aliases[i]
+			}
+			if alias == ref.name || (len(alias) == 0 && sym == ref.name) {
+				matches = append(matches, i+1)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			err = &GrammarError{Position: pos, Msg: fmt.Sprintf("unknown reference %s in rule %s", ref, prod.name)}
+		case 1:
+			resolved := actionRef{tag: ref.tag, lhs: matches[0] == 0, index: matches[0], loc: ref.loc}
+			return resolved.String()
+		default:
+			err = &GrammarError{Position: pos, Msg: fmt.Sprintf("ambiguous reference %s in rule %s", ref, prod.name)}
+		}
+		return ref.String()
+	})
+	return err
+}
+
+// untypedRef tells why the value ref of production prod has no field to be
+// used: $$ and the values of the body need the type of their symbol, a value
+// left of the rule an explicit <field>. It returns "" if there is a field.
+func (self *Grammar) untypedRef(prod *Production, ref actionRef) string {
+	if ref.loc || len(ref.tag) > 0 {
+		return ""
+	}
+	switch {
+	case ref.lhs:
+		if len(self.nontermTypes[prod.name]) == 0 {
+			return fmt.Sprintf("%s refers to %s, which has no type in rule %s", ref, prod.name, prod.name)
+		}
+	case ref.index <= 0:
+		return fmt.Sprintf("%s needs a <field> in rule %s", ref, prod.name)
+	case ref.index <= len(prod.body) && len(self.symbolType(prod.body[ref.index-1])) == 0:
+		return fmt.Sprintf("%s refers to %s, which has no type in rule %s", ref, prod.body[ref.index-1], prod.name)
+	}
+	return ""
+}
+
+// checkDefaultCode checks that the references of %defaultcode fit every
+// rule taking it.
+func (self *Grammar) checkDefaultCode() error {
+	for _, prod := range self.prods {
+		if len(prod.code) > 0 {
+			continue
+		}
+		for _, ref := range findActionRefs(self.defaultcode) {
+			pos := codePosition(self.defaultPos, self.defaultcode, ref.start)
+			if len(ref.name) > 0 {
+				return &GrammarError{Position: pos, Msg: fmt.Sprintf("named reference %s in %%defaultcode", ref)}
+			}
+			if !ref.lhs && ref.index > len(prod.body) {
+				return &GrammarError{Position: pos,
+					Msg: fmt.Sprintf("%s of %%defaultcode is out of range in %s", ref, prod)}
+			}
+		}
+	}
+	return nil
+}
+
+// codePosition is the position of byte offset of code, which starts at
+// pos.
+func codePosition(pos Position, code string, offset int) Position {
+	for i := 0; i < offset; i++ {
+		pos.Offset++
+		pos.Column++
+		if code[i] == '\n' {
+			pos.Line++
+			pos.Column = 1
+		}
+	}
+	return pos
+}
+
+// actionValue is the Go expression a reference stands for in yyruncode of
+// production prod. Values of the body are popped into rhs_N, values left of
+// the rule are still on the stack. Spans are all still on their stack, the
+// span of the rule is yyloc.
+func (self *Grammar) actionValue(prod *Production, ref actionRef) string {
+	field := ref.tag
+	switch {
+	case ref.loc && ref.lhs:
+		return "yyloc"
+	case ref.loc:
+		return fmt.Sprintf("spans.peek(%d)", len(prod.body)-ref.index)
+	case self.typed && ref.lhs:
+		return "lhs"
+	case self.typed && ref.index >= 1 && ref.index <= len(prod.body):
+		return fmt.Sprintf("rhs_%d", ref.index)
+	case ref.lhs:
+		if len(field) == 0 {
+			field = self.nontermTypes[prod.name]
+		}
+		return "lhs." + field
+	case ref.index >= 1 && ref.index <= len(prod.body):
+		if len(field) == 0 {
+			field = self.symbolType(prod.body[ref.index-1])
+		}
+		return fmt.Sprintf("rhs_%d.%s", ref.index, field)
+	case ref.index <= 0:
+		return fmt.Sprintf("values.peek(%d).%s", -ref.index, field)
+	}
+	return ref.String()
+}
+
+// checkTypes checks, for typed values, that every value an action uses has
+// a declared type and that the <field> of a reference agrees with it.
+func (self *Grammar) checkTypes() error {
+	for _, prod := range self.prods {
+		code, codePos := prod.code, prod.codePos
+		if len(code) == 0 {
+			code, codePos = self.defaultcode, self.defaultPos
+		}
+		for _, ref := range findActionRefs(code) {
+			if ref.loc {
+				continue
+			}
+			sym, declared := "", ref.tag
+			switch {
+			case ref.lhs:
+				sym, declared = prod.name, self.nontermTypes[prod.name]
+			case ref.index >= 1 && ref.index <= len(prod.body):
+				sym = prod.body[ref.index-1]
+				declared = self.symbolType(sym)
+			}
+			msg := ""
+			switch {
+			case len(declared) == 0 && len(sym) > 0:
+				msg = fmt.Sprintf("%s refers to %s, which has no type", ref, sym)
+			case len(declared) == 0:
+				msg = fmt.Sprintf("%s needs a <field>", ref)
+			case len(ref.tag) > 0 && ref.tag != declared:
+				msg = fmt.Sprintf("%s does not match the type <%s> of %s", ref, declared, sym)
+			case len(self.unionTypes[declared]) == 0:
+				msg = fmt.Sprintf("%s uses %s, which is not a %%union field", ref, declared)
+			}
+			if len(msg) > 0 {
+				return &GrammarError{Position: codePosition(codePos, code, ref.start), Msg: msg + " in rule " + prod.name}
+			}
+		}
+	}
+	return nil
+}
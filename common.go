@@ -1,26 +1,105 @@
 package parser
 
+import (
+	"io"
+)
+
 type Production struct {
 	name string
 	body []string
 	code string
+
+	// where the alternative starts (its ':' or '|'), where each body
+	// symbol and the code block were found
+	pos     Position
+	bodyPos []Position
+	codePos Position
+
+	// the token named by %prec, if any
+	prec string
+	// names given to body symbols as in Sym[name], "" if none
+	aliases []string
+	// symbols below the body that the span of the rule takes in, for rules
+	// running the action of another one
+	spanBelow int
 }
 
-var MINTOKEN int
-var MAXTOKEN int
-var literalSet map[string]int
-var tokenSet map[string]int
-var symbolSet map[string]int
+func (prod Production) String() string {
+	text := prod.name + " :"
+	if len(prod.body) == 0 {
+		return text + " /* empty */"
+	}
+	for _, sym := range prod.body {
+		text += " " + sym
+	}
+	return text
+}
 
-var prods []Production
+// Grammar owns the symbols, productions and header settings read from one
+// grammar file.
+type Grammar struct {
+	minToken   int
+	maxToken   int
+	literalSet map[string]int
+	tokenSet   map[string]int
+	symbolSet  map[string]int
 
-var firsts map[int]int
-var follows map[int]int
+	prods []Production
 
-// header info variables
-var packagename string
-var modules []string
-var defaultcode string
-var unionTypes map[string]string
-var termTypes map[string]string
-var nontermTypes map[string]string
+	// header info
+	packagename  string
+	modules      []string
+	defaultcode  string
+	defaultPos   Position
+	unionTypes   map[string]string
+	termTypes    map[string]string
+	nontermTypes map[string]string
+	precs        map[string]Precedence
+	// symbols named by %start, where they were named
+	starts   []string
+	startPos []Position
+	// actions get typed variables, see Generator.TypedValues
+	typed bool
+	// the %token and %skip patterns in order, and the lexer built from
+	// them, nil if there are none
+	patterns []lexPattern
+	lexer    *lexDFA
+}
+
+func NewGrammar() *Grammar {
+	return &Grammar{
+		literalSet:   make(map[string]int),
+		tokenSet:     make(map[string]int),
+		symbolSet:    make(map[string]int),
+		prods:        make([]Production, 0),
+		modules:      make([]string, 0),
+		unionTypes:   make(map[string]string),
+		termTypes:    make(map[string]string),
+		nontermTypes: make(map[string]string),
+		precs:        make(map[string]Precedence),
+	}
+}
+
+// Generator turns grammar files into parsers. It keeps no state between
+// runs, so one Generator can serve several goroutines at once.
+type Generator struct {
+	// what LLParser does when the LL table has conflicts
+	ConflictPolicy ConflictPolicy
+	// words of lookahead the parser decides on, 1 if not set
+	Lookahead int
+	// rewrite left-recursive rules before the tables are built
+	EliminateLeftRecursion bool
+	// move prefixes shared by alternatives into helper nonterminals
+	LeftFactor bool
+	// if set, the rules the tables are built from are written here
+	GrammarDump io.Writer
+	// how LRParser builds its automaton, LALR if not set
+	LRMethod LRMethod
+	// if set, LRParser writes the size of every kind of table here
+	StateReport io.Writer
+	// give actions a variable of the declared type for every value they
+	// use, so the compiler checks them, and reject references whose <field>
+	// differs from the declaration. The stacks still hold every value in a
+	// *yytype with all the %union fields.
+	TypedValues bool
+}
@@ -20,17 +20,17 @@ func ComputeFirsts(prods []Production,
 	for {
 		for _, prod := range prods {
 			lhs := firsts[prod.name]
-		Inner:
+			// ε only belongs to FIRST when the whole body can be empty
+			empty := true
 			for _, sym := range prod.body {
-				lhs, tmpBool = mergeSets(lhs, firsts[sym])
+				lhs, tmpBool = mergeSetsNoE(lhs, firsts[sym])
 				changed = changed || tmpBool
-				if indexValue(firsts[sym], 0) != -1 {
-					continue
-				} else {
-					break Inner
+				if indexValue(firsts[sym], 0) == -1 {
+					empty = false
+					break
 				}
 			}
-			if len(prod.body) == 0 {
+			if empty {
 				lhs, tmpBool = mergeSets(lhs, firsts[""])
 				changed = changed || tmpBool
 			}
@@ -45,9 +45,17 @@ func ComputeFirsts(prods []Production,
 	return firsts
 }
 
-func ComputeFollows(prods []Production, tokens map[string]int, firsts map[string][]int) map[string][]int {
+// ComputeFollows computes FOLLOW of every nonterminal. The input ends after
+// the start symbols, the first production's name unless given.
+func ComputeFollows(prods []Production,
+	tokens map[string]int,
+	firsts map[string][]int,
+	maxterm int,
+	starts ...string) map[string][]int {
 	follows := make(map[string][]int)
-	follows[prods[0].name] = []int{1}
+	for _, start := range startSymbols(prods, starts) {
+		follows[start] = []int{1}
+	}
 
 	changed := false
 	var tmpBool bool
@@ -56,40 +64,27 @@ func ComputeFollows(prods []Production, tokens map[string]int, firsts map[string
 			if len(prod.body) == 0 {
 				continue
 			}
-			nbody := len(prod.body) - 1
+			// walking the body from the right, rest is FIRST of the symbols
+			// after the current one, emptyTail tells if they can all vanish
+			rest := make([]int, 0)
 			emptyTail := true
-			if tokens[prod.body[nbody]] > MAXTOKEN {
-				lhs := follows[prod.body[nbody]]
-				rhs := follows[prod.name]
-				lhs, tmpBool = mergeSetsNoE(lhs, rhs)
-				follows[prod.body[nbody]] = lhs
-				changed = changed || tmpBool
-
-				if indexValue(firsts[prod.body[nbody]], 0) == -1 {
-					emptyTail = false
-				}
-			} else {
-				emptyTail = false
-			}
-			for i := nbody - 1; i >= 0; i-- {
+			for i := len(prod.body) - 1; i >= 0; i-- {
 				tokName := prod.body[i]
-				if tokens[tokName] > MAXTOKEN {
+				if tokens[tokName] > maxterm {
 					lhs := follows[tokName]
-					rhs := firsts[prod.body[i+1]]
-					lhs, tmpBool = mergeSetsNoE(lhs, rhs)
-					follows[tokName] = lhs
+					lhs, tmpBool = mergeSetsNoE(lhs, rest)
 					changed = changed || tmpBool
-				}
-				if emptyTail {
-					lhs := follows[tokName]
-					rhs := follows[prod.name]
-					lhs, tmpBool = mergeSetsNoE(lhs, rhs)
-					follows[tokName] = lhs
-					changed = changed || tmpBool
-					if indexValue(firsts[prod.body[i]], 0) == -1 {
-						emptyTail = false
+					if emptyTail {
+						lhs, tmpBool = mergeSetsNoE(lhs, follows[prod.name])
+						changed = changed || tmpBool
 					}
+					follows[tokName] = lhs
 				}
+				if indexValue(firsts[tokName], 0) == -1 {
+					rest = make([]int, 0)
+					emptyTail = false
+				}
+				rest, _ = mergeSetsNoE(rest, firsts[tokName])
 			}
 		}
 		if !changed {
@@ -102,11 +97,14 @@ func ComputeFollows(prods []Production, tokens map[string]int, firsts map[string
 	return follows
 }
 
+// ComputeLLTable builds the predictive table. When two productions of the
+// same nonterminal compete for a cell the first one is kept and the clash is
+// returned as a Conflict, so callers decide whether to accept the table.
 func ComputeLLTable(prods []Production,
 	tokens map[string]int,
 	firsts map[string][]int,
 	follows map[string][]int,
-	symBegin, symEnd int) map[int][]int {
+	symBegin, symEnd int) (map[int][]int, []Conflict) {
 	lltable := make(map[int][]int)
 	// init table
 	for i := symBegin; i <= symEnd; i++ {
@@ -115,13 +113,51 @@ func ComputeLLTable(prods []Production,
 			lltable[i][idx] = -1
 		}
 	}
+	// fromFollow records whether a cell was filled through FOLLOW
+	fromFollow := make(map[int][]bool)
+	for i := symBegin; i <= symEnd; i++ {
+		fromFollow[i] = make([]bool, symBegin)
+	}
+	names := make(map[int]string)
+	for name, id := range tokens {
+		names[id] = name
+	}
+
+	conflicts := make([]Conflict, 0)
+	setCell := func(pidx, tok int, follow bool) {
+		row := tokens[prods[pidx].name]
+		old := lltable[row][tok]
+		if old == -1 {
+			lltable[row][tok] = pidx
+			fromFollow[row][tok] = follow
+			return
+		}
+		if old == pidx {
+			return
+		}
+		// a cell either production reaches through FOLLOW is a FIRST/FOLLOW
+		// clash, as in ComputeLLkTable
+		kind := FirstFirst
+		if follow || fromFollow[row][tok] {
+			kind = FirstFollow
+		}
+		conflicts = append(conflicts, Conflict{
+			Kind:      kind,
+			Nonterm:   prods[pidx].name,
+			Lookahead: names[tok],
+			Prods:     [2]int{old, pidx},
+			Bodies:    [2]Production{prods[old], prods[pidx]},
+		})
+	}
 
 	for i, prod := range prods {
 		nullable := true
 
 		for _, body := range prod.body {
 			for _, tok := range firsts[body] {
-				lltable[tokens[prod.name]][tok] = i
+				if tok != 0 {
+					setCell(i, tok, false)
+				}
 			}
 			if indexValue(firsts[body], 0) == -1 {
 				nullable = false
@@ -132,12 +168,12 @@ func ComputeLLTable(prods []Production,
 		}
 		if nullable {
 			for _, tok := range follows[prod.name] {
-				lltable[tokens[prod.name]][tok] = i
+				setCell(i, tok, true)
 			}
 		}
 	}
 
-	return lltable
+	return lltable, conflicts
 }
 
 func mergeSets(lhs []int, rhs []int) ([]int, bool) {
@@ -173,3 +209,12 @@ func indexValue(lhs []int, val int) int {
 	}
 	return -1
 }
+
+// startSymbols returns starts, or the name of the first production when
+// none is given.
+func startSymbols(prods []Production, starts []string) []string {
+	if len(starts) == 0 {
+		return []string{prods[0].name}
+	}
+	return starts
+}
@@ -31,18 +31,18 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 %%`
 
 func TestComputeFirsts(t *testing.T) {
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
+	t.Parallel()
+	grammar := NewGrammar()
 
 	scanner := &Scanner{content: []byte(content), index: 0}
-	ParseGrammars(scanner)
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	mergedSymbols := grammar.MergeSymbols()
 
 	parserLog("All Symbols: %v", mergedSymbols)
 
-	firsts := ComputeFirsts(prods, mergedSymbols, MAXTOKEN)
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
 
 	expectedFirsts := map[string][]int{
 		"":         []int{0},
@@ -73,17 +73,17 @@ func TestComputeFirsts(t *testing.T) {
 }
 
 func TestComputeFollows(t *testing.T) {
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
+	t.Parallel()
+	grammar := NewGrammar()
 
 	scanner := &Scanner{content: []byte(content), index: 0}
-	ParseGrammars(scanner)
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
 
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
-	firsts := ComputeFirsts(prods, mergedSymbols, MAXTOKEN)
-	follows := ComputeFollows(prods, mergedSymbols, firsts)
+	mergedSymbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken)
 
 	expectedFollows := map[string][]int{
 		"Calc":  []int{1},
@@ -106,18 +106,18 @@ func TestComputeFollows(t *testing.T) {
 }
 
 func TestComputeLLTable(t *testing.T) {
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
+	t.Parallel()
+	grammar := NewGrammar()
 
 	scanner := &Scanner{content: []byte(content), index: 0}
-	ParseGrammars(scanner)
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
 
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
-	firsts := ComputeFirsts(prods, mergedSymbols, MAXTOKEN)
-	follows := ComputeFollows(prods, mergedSymbols, firsts)
-	lltable := ComputeLLTable(prods, mergedSymbols, firsts, follows, MAXTOKEN+1, len(mergedSymbols)-1)
+	mergedSymbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken)
+	lltable, conflicts := ComputeLLTable(grammar.prods, mergedSymbols, firsts, follows, grammar.maxToken+1, len(mergedSymbols)-1)
 
 	expectedLLTable := map[int][]int{
 		8:  []int{-1, -1, -1, -1, -1, -1, 0, 0},
@@ -128,6 +128,9 @@ func TestComputeLLTable(t *testing.T) {
 		11: []int{-1, -1, -1, -1, -1, -1, 9, 10},
 	}
 	parserLog("Got LL Table:\n%v\n", lltable)
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+	}
 	for k, v := range expectedLLTable {
 		if _, b := lltable[k]; !b {
 			t.Errorf("Expected a row for %d", k)
@@ -141,6 +144,7 @@ func TestComputeLLTable(t *testing.T) {
 }
 
 func TestComputeFirstsFollows(t *testing.T) {
+	t.Parallel()
 	content := `
 E  : T E2
    ;
@@ -161,15 +165,14 @@ F  : '(' E ')'
    ;`
 
 	scanner := &Scanner{content: []byte(content), index: 0}
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
+	grammar := NewGrammar()
 
-	ParseGrammars(scanner)
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
-	firsts := ComputeFirsts(prods, mergedSymbols, MAXTOKEN)
-	follows := ComputeFollows(prods, mergedSymbols, firsts)
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	mergedSymbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken)
 
 	expectedMergedSymbols := map[string]int{
 		"":    0,
@@ -228,7 +231,7 @@ F  : '(' E ')'
 		}
 	}
 
-	lltable := ComputeLLTable(prods, mergedSymbols, firsts, follows, MAXTOKEN+1, len(mergedSymbols)-1)
+	lltable, conflicts := ComputeLLTable(grammar.prods, mergedSymbols, firsts, follows, grammar.maxToken+1, len(mergedSymbols)-1)
 	expectedLLTable := map[int][]int{
 		7:  []int{-1, -1, -1, -1, 0, -1, 0},
 		9:  []int{-1, 2, 1, -1, -1, 2, -1},
@@ -237,6 +240,9 @@ F  : '(' E ')'
 		10: []int{-1, -1, -1, -1, 6, -1, 7},
 	}
 	parserLog("Got LL Table:\n%v\n", lltable)
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+	}
 	for k, v := range expectedLLTable {
 		if _, b := lltable[k]; !b {
 			t.Errorf("Expected a row for %d", k)
@@ -249,6 +255,119 @@ F  : '(' E ')'
 	}
 }
 
+func TestComputeFirstsFollowsNullablePrefix(t *testing.T) {
+	t.Parallel()
+	// A and B can vanish, so what follows them shows through, but only the
+	// whole body of S or C decides whether they can be empty
+	content := `
+S : A B ';'
+  | C
+  ;
+
+C : A '.'
+  ;
+
+A : a
+  |
+  ;
+
+B : b
+  |
+  ;`
+
+	scanner := &Scanner{content: []byte(content), index: 0}
+	grammar := NewGrammar()
+
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	mergedSymbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken)
+
+	ids := func(names ...string) []int {
+		set := make([]int, len(names))
+		for i, name := range names {
+			set[i] = mergedSymbols[name]
+		}
+		return set
+	}
+	expectedFirsts := map[string][]int{
+		"S": ids("a", "b", "';'", "'.'"),
+		"C": ids("a", "'.'"),
+		"A": ids("", "a"),
+		"B": ids("", "b"),
+	}
+	for k, rhs := range expectedFirsts {
+		if lhs := firsts[k]; !cmpArraySorted(lhs, rhs) {
+			t.Errorf("Expected FIRST %v for key %s, got %v", rhs, k, lhs)
+		}
+	}
+	expectedFollows := map[string][]int{
+		"S": ids("$"),
+		"C": ids("$"),
+		"A": ids("b", "';'", "'.'"),
+		"B": ids("';'"),
+	}
+	for k, rhs := range expectedFollows {
+		if lhs := follows[k]; !cmpArraySorted(lhs, rhs) {
+			t.Errorf("Expected FOLLOW %v for key %s, got %v", rhs, k, lhs)
+		}
+	}
+}
+
+func TestComputeLLTableConflicts(t *testing.T) {
+	t.Parallel()
+	content := `
+S : id '=' E
+  | id '(' ')'
+  | O 'x'
+  ;
+
+O : 'x'
+  |
+  ;
+
+E : id
+  ;`
+
+	scanner := &Scanner{content: []byte(content), index: 0}
+	grammar := NewGrammar()
+
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	mergedSymbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken)
+	lltable, conflicts := ComputeLLTable(grammar.prods, mergedSymbols, firsts, follows, grammar.maxToken+1, len(mergedSymbols)-1)
+
+	expected := []Conflict{
+		{Kind: FirstFirst, Nonterm: "S", Lookahead: "id", Prods: [2]int{0, 1}},
+		{Kind: FirstFollow, Nonterm: "O", Lookahead: "'x'", Prods: [2]int{3, 4}},
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts, got %v", len(expected), conflicts)
+	}
+	for i, c := range expected {
+		got := conflicts[i]
+		if got.Kind != c.Kind || got.Nonterm != c.Nonterm ||
+			got.Lookahead != c.Lookahead || got.Prods != c.Prods {
+			t.Errorf("Expected conflict %v, got %v", c, got)
+		}
+	}
+	report := "3:3: FIRST/FIRST conflict in S on id: [1] S : id '(' ')' clashes with [0] S : id '=' E at 2:3"
+	if conflicts[0].String() != report {
+		t.Errorf("Expected report:\n\t%s\nGot:\n\t%s", report, conflicts[0])
+	}
+	if lltable[mergedSymbols["S"]][mergedSymbols["id"]] != 0 {
+		t.Errorf("Expected the first alternative of S to be kept")
+	}
+	if lltable[mergedSymbols["O"]][mergedSymbols["'x'"]] != 3 {
+		t.Errorf("Expected the first alternative of O to be kept")
+	}
+}
+
 func cmpArraySorted(lhs []int, rhs []int) bool {
 	if len(lhs) != len(rhs) {
 		return false
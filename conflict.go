@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+)
+
+type ConflictKind int
+
+const (
+	FirstFirst ConflictKind = iota
+	FirstFollow
+	ShiftReduce
+	ReduceReduce
+)
+
+func (kind ConflictKind) String() string {
+	switch kind {
+	case FirstFirst:
+		return "FIRST/FIRST"
+	case FirstFollow:
+		return "FIRST/FOLLOW"
+	case ShiftReduce:
+		return "shift/reduce"
+	case ReduceReduce:
+		return "reduce/reduce"
+	}
+	return "unknown"
+}
+
+// ConflictPolicy decides what the generator does with a table that has
+// conflicts.
+type ConflictPolicy int
+
+const (
+	// FailOnConflict reports the conflicts and generates nothing
+	FailOnConflict ConflictPolicy = iota
+	// PreferFirst keeps the alternative written first in the grammar, LR
+	// tables shift rather than reduce as yacc does
+	PreferFirst
+)
+
+// Assoc is the associativity of an operator.
+type Assoc int
+
+const (
+	// LeftAssoc is declared by %left: a - b - c is (a - b) - c
+	LeftAssoc Assoc = iota
+	// RightAssoc is declared by %right: a = b = c is a = (b = c)
+	RightAssoc
+	// NonAssoc is declared by %nonassoc: a < b < c is an error
+	NonAssoc
+)
+
+// Precedence is what %left, %right and %nonassoc declare for a token. Later
+// lines get higher levels and bind tighter. LR tables use it to resolve
+// shift/reduce conflicts between the token and the productions ending with
+// a token of known precedence or naming one with %prec.
+type Precedence struct {
+	Level int
+	Assoc Assoc
+}
+
+// Conflict describes two productions competing for the same lookahead.
+// Prods[0] is the production kept in the table. In LR tables it is the one
+// shifting or reduced first in State, Nonterm is the one losing.
+type Conflict struct {
+	Kind      ConflictKind
+	Nonterm   string
+	Lookahead string
+	State     int
+	Prods     [2]int
+	Bodies    [2]Production
+}
+
+func (self Conflict) String() string {
+	where := self.Nonterm
+	if self.Kind == ShiftReduce || self.Kind == ReduceReduce {
+		where = fmt.Sprintf("state %d", self.State)
+	}
+	return fmt.Sprintf("%s: %s conflict in %s on %s: [%d] %s clashes with [%d] %s at %s",
+		self.Bodies[1].pos, self.Kind, where, self.Lookahead,
+		self.Prods[1], self.Bodies[1], self.Prods[0], self.Bodies[0], self.Bodies[0].pos)
+}
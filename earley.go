@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ParseTree is a derivation found by Parse. Words are leaves, with Prod -1.
+type ParseTree struct {
+	Symbol   string
+	Prod     int
+	Children []*ParseTree
+}
+
+// String writes the tree as (Symbol children...), words as they are.
+func (self *ParseTree) String() string {
+	if self.Prod < 0 {
+		return self.Symbol
+	}
+	parts := []string{self.Symbol}
+	for _, child := range self.Children {
+		parts = append(parts, child.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// earleyItem is a production with a dot in front of body[dot], started
+// after origin words.
+type earleyItem struct {
+	prod, dot, origin int
+}
+
+// earley holds the item sets of one Parse run.
+type earley struct {
+	grammar  *Grammar
+	start    string
+	isTerm   func(name string) bool
+	byName   map[string][]int
+	nullable map[string]bool
+	words    []string
+	sets     [][]earleyItem
+	seen     []map[earleyItem]bool
+	active   map[[4]int]bool
+}
+
+// Parse runs an Earley parser over words, the terminals as written in the
+// grammar (id, '+' or +), and returns a derivation of start, one of the
+// start symbols, or of the first one if start is "". Any context-free grammar
+// will do; on ambiguous input the productions written first and the shortest
+// last symbols are preferred. The grammar is only read, so Parse may run
+// concurrently.
+func (self *Grammar) Parse(start string, words []string) (*ParseTree, error) {
+	if len(self.prods) == 0 {
+		return nil, errors.New("grammar has no rules")
+	}
+	starts := self.startSymbols()
+	if len(start) == 0 {
+		start = starts[0]
+	} else if !slices.Contains(starts, start) {
+		return nil, fmt.Errorf("%s is not a start symbol", start)
+	}
+	isTerm := func(name string) bool {
+		_, lit := self.literalSet[name]
+		_, tok := self.tokenSet[name]
+		return lit || tok
+	}
+	parser := &earley{
+		grammar:  self,
+		start:    start,
+		isTerm:   isTerm,
+		byName:   make(map[string][]int),
+		nullable: make(map[string]bool),
+		words:    make([]string, len(words)),
+		sets:     make([][]earleyItem, len(words)+1),
+		seen:     make([]map[earleyItem]bool, len(words)+1),
+		active:   make(map[[4]int]bool),
+	}
+	for i, word := range words {
+		name := word
+		if !isTerm(name) {
+			name = fmt.Sprintf("'%s'", word)
+		}
+		if !isTerm(name) {
+			return nil, fmt.Errorf("unknown word %q", word)
+		}
+		parser.words[i] = name
+	}
+	for i, prod := range self.prods {
+		parser.byName[prod.name] = append(parser.byName[prod.name], i)
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, prod := range self.prods {
+			empty := !parser.nullable[prod.name]
+			for _, sym := range prod.body {
+				empty = empty && parser.nullable[sym]
+			}
+			if empty {
+				parser.nullable[prod.name] = true
+				changed = true
+			}
+		}
+	}
+
+	for i := range parser.sets {
+		parser.seen[i] = make(map[earleyItem]bool)
+	}
+	for _, prod := range parser.byName[start] {
+		parser.add(0, earleyItem{prod, 0, 0})
+	}
+	for i := range parser.sets {
+		parser.scan(i)
+		if i < len(words) && len(parser.sets[i+1]) == 0 {
+			return nil, fmt.Errorf("unexpected %s after %d words, expected %s", words[i], i, parser.expected(i))
+		}
+	}
+
+	end := len(words)
+	for _, prod := range parser.byName[start] {
+		if tree, b := parser.build(earleyItem{prod, len(self.prods[prod].body), 0}, end); b {
+			return tree, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected end of input after %d words, expected %s", end, parser.expected(end))
+}
+
+func (self *earley) add(i int, item earleyItem) {
+	if !self.seen[i][item] {
+		self.seen[i][item] = true
+		self.sets[i] = append(self.sets[i], item)
+	}
+}
+
+// scan predicts, completes and shifts the items of set i until none is
+// added. Items in front of nullable symbols move past them right away, so
+// empty rules need not be completed later.
+func (self *earley) scan(i int) {
+	for n := 0; n < len(self.sets[i]); n++ {
+		item := self.sets[i][n]
+		prod := &self.grammar.prods[item.prod]
+		if item.dot == len(prod.body) {
+			for p := 0; p < len(self.sets[item.origin]); p++ {
+				parent := self.sets[item.origin][p]
+				body := self.grammar.prods[parent.prod].body
+				if parent.dot < len(body) && body[parent.dot] == prod.name {
+					self.add(i, earleyItem{parent.prod, parent.dot + 1, parent.origin})
+				}
+			}
+			continue
+		}
+		sym := prod.body[item.dot]
+		next := earleyItem{item.prod, item.dot + 1, item.origin}
+		if self.isTerm(sym) {
+			if i < len(self.words) && self.words[i] == sym {
+				self.add(i+1, next)
+			}
+			continue
+		}
+		for _, p := range self.byName[sym] {
+			self.add(i, earleyItem{p, 0, i})
+		}
+		if self.nullable[sym] {
+			self.add(i, next)
+		}
+	}
+}
+
+// expected lists the terminals set i could move on, $ if the input could
+// end there.
+func (self *earley) expected(i int) string {
+	names := make(map[string]bool)
+	for _, item := range self.sets[i] {
+		body := self.grammar.prods[item.prod].body
+		if item.dot < len(body) && self.isTerm(body[item.dot]) {
+			names[body[item.dot]] = true
+		}
+	}
+	for _, item := range self.sets[i] {
+		if item.origin == 0 && item.dot == len(self.grammar.prods[item.prod].body) &&
+			self.grammar.prods[item.prod].name == self.start {
+			names["$"] = true
+		}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return "{" + strings.Join(list, ", ") + "}"
+}
+
+// build returns the tree of a complete item ending after end words.
+// Items being built are skipped, which cuts cycles of unit rules.
+func (self *earley) build(item earleyItem, end int) (*ParseTree, bool) {
+	key := [4]int{item.prod, item.dot, item.origin, end}
+	if !self.seen[end][item] || self.active[key] {
+		return nil, false
+	}
+	self.active[key] = true
+	defer delete(self.active, key)
+	children, b := self.children(item, end)
+	if !b {
+		return nil, false
+	}
+	return &ParseTree{Symbol: self.grammar.prods[item.prod].name, Prod: item.prod, Children: children}, true
+}
+
+// children finds the trees of the symbols before the dot of item, the last
+// one ending after end words.
+func (self *earley) children(item earleyItem, end int) ([]*ParseTree, bool) {
+	if item.dot == 0 {
+		return make([]*ParseTree, 0), item.origin == end
+	}
+	sym := self.grammar.prods[item.prod].body[item.dot-1]
+	prev := earleyItem{item.prod, item.dot - 1, item.origin}
+	if self.isTerm(sym) {
+		if end == item.origin || self.words[end-1] != sym || !self.seen[end-1][prev] {
+			return nil, false
+		}
+		children, b := self.children(prev, end-1)
+		return append(children, &ParseTree{Symbol: sym, Prod: -1}), b
+	}
+	for k := end; k >= item.origin; k-- {
+		if !self.seen[k][prev] {
+			continue
+		}
+		for _, p := range self.byName[sym] {
+			last, b := self.build(earleyItem{p, len(self.grammar.prods[p].body), k}, end)
+			if !b {
+				continue
+			}
+			if children, b := self.children(prev, k); b {
+				return append(children, last), true
+			}
+		}
+	}
+	return nil, false
+}
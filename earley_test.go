@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, expressions)
+	cases := map[string]string{
+		"id":               "(E (T (F id)))",
+		"id + id * id":     "(E (E (T (F id))) '+' (T (T (F id)) '*' (F id)))",
+		"( id + id ) * id": "(E (T (T (F '(' (E (E (T (F id))) '+' (T (F id))) ')')) '*' (F id)))",
+	}
+	for input, expected := range cases {
+		tree, err := grammar.Parse("", strings.Fields(input))
+		if err != nil {
+			t.Errorf("Parsing %s: %v", input, err)
+		} else if tree.String() != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, tree)
+		}
+	}
+
+	errs := map[string]string{
+		"id + * id": "unexpected * after 2 words, expected {'(', id}",
+		"id +":      "unexpected end of input after 2 words, expected {'(', id}",
+		"id id":     "unexpected id after 1 words, expected {$, '*', '+'}",
+		"id - id":   `unknown word "-"`,
+	}
+	for input, expected := range errs {
+		if _, err := grammar.Parse("", strings.Fields(input)); err == nil || err.Error() != expected {
+			t.Errorf("Expected %q for %s, got %v", expected, input, err)
+		}
+	}
+}
+
+func TestParseGeneral(t *testing.T) {
+	t.Parallel()
+	// ambiguous, left recursive through an empty rule and cyclic
+	grammar := parseTestGrammar(t, `%%
+S : A S 'x'
+  | E
+  ;
+A :
+  ;
+E : E '+' E
+  | id
+  | E
+  ;`)
+	cases := map[string]string{
+		"id + id + id": "(S (E (E (E id) '+' (E id)) '+' (E id)))",
+		"id x x":       "(S (A) (S (A) (S (E id)) 'x') 'x')",
+	}
+	for input, expected := range cases {
+		tree, err := grammar.Parse("", strings.Fields(input))
+		if err != nil {
+			t.Errorf("Parsing %s: %v", input, err)
+		} else if tree.String() != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, tree)
+		}
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%start S T
+%%
+S : id '=' T
+  ;
+T : id
+  | T '+' id
+  ;`)
+	cases := []struct {
+		start, input, expected string
+	}{
+		{"", "id = id + id", "(S id '=' (T (T id) '+' id))"},
+		{"S", "id = id", "(S id '=' (T id))"},
+		{"T", "id + id", "(T (T id) '+' id)"},
+		{"T", "id = id", "unexpected = after 1 words, expected {$, '+'}"},
+		{"X", "id", "X is not a start symbol"},
+	}
+	for _, c := range cases {
+		tree, err := grammar.Parse(c.start, strings.Fields(c.input))
+		if err != nil {
+			if err.Error() != c.expected {
+				t.Errorf("Expected %s for %s from %s, got %v", c.expected, c.input, c.start, err)
+			}
+		} else if tree.String() != c.expected {
+			t.Errorf("Expected %s for %s from %s, got %s", c.expected, c.input, c.start, tree)
+		}
+	}
+	if grammar.minToken != 0 || grammar.maxToken != 0 {
+		t.Errorf("Expected Parse to leave the grammar as it is, got token ids %d-%d", grammar.minToken, grammar.maxToken)
+	}
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseItem reads the symbol or group starting with word and the EBNF
+// operators following it, and returns the symbol standing for them in the
+// body of rule along with the name given to it as in Sym[name] or
+// (α | β)*[name].
+func (self *Grammar) parseItem(scanner *Scanner, rule string, word WordTok, helpers *[]Production) (string, string, error) {
+	sym, alias := word.text, ""
+	if word.tokType == lparen {
+		var err error
+		if sym, err = self.parseGroup(scanner, rule, word, helpers); err != nil {
+			return "", "", err
+		}
+	} else {
+		word.text, alias = splitAlias(word.text)
+		sym = word.text
+		self.eatSymbol(&word)
+	}
+	for {
+		err, op := scanner.peekWord()
+		if err == nil && len(alias) == 0 && op.text[0] == '[' {
+			if _, name := splitAlias(op.text); len(name) > 0 && len(name) == len(op.text)-2 {
+				scanner.NextWord()
+				return sym, name, nil
+			}
+		}
+		if err != nil || op.tokType != repeat || len(alias) > 0 {
+			return sym, alias, nil
+		}
+		scanner.NextWord()
+		sym = self.lowerRepeat(rule, sym, op, helpers)
+	}
+}
+
+// splitAlias splits Sym[name] into Sym and name. Words without a name are
+// returned as they are.
+func splitAlias(text string) (string, string) {
+	i := strings.LastIndexByte(text, '[')
+	if i < 0 || !strings.HasSuffix(text, "]") || !isRefName(text[i+1:len(text)-1]) {
+		return text, ""
+	}
+	if text[0] == '\'' && (i < 2 || text[i-1] != '\'') {
+		return text, ""
+	}
+	return text[:i], text[i+1 : len(text)-1]
+}
+
+// parseGroup reads the alternatives of `( α | β )` up to the closing
+// parenthesis into a helper rule. When every alternative is one symbol of
+// the same type, the group has that type and passes the value on.
+func (self *Grammar) parseGroup(scanner *Scanner, rule string, open WordTok, helpers *[]Production) (string, error) {
+	alts := make([]Production, 0)
+	alt := Production{pos: open.pos}
+	for {
+		err, word := scanner.NextWord()
+		if err == errEOF {
+			return "", scanner.wordError(open, "unclosed '(' in rule %s", rule)
+		}
+		if err != nil {
+			return "", err
+		}
+		switch word.tokType {
+		case newline:
+			continue
+		case nonterm, term, literal, lparen:
+			sym, _, err := self.parseItem(scanner, rule, word, helpers)
+			if err != nil {
+				return "", err
+			}
+			alt.body = append(alt.body, sym)
+			alt.bodyPos = append(alt.bodyPos, word.pos)
+			continue
+		case alternate:
+			alts = append(alts, alt)
+			alt = Production{pos: word.pos}
+			continue
+		case rparen:
+			alts = append(alts, alt)
+		default:
+			return "", scanner.wordError(word, "unexpected %q in group", word.text)
+		}
+		break
+	}
+
+	field := ""
+	for i, alt := range alts {
+		f := ""
+		if len(alt.body) == 1 {
+			f = self.symbolType(alt.body[0])
+		}
+		if len(f) == 0 || (i > 0 && f != field) {
+			field = ""
+			break
+		}
+		field = f
+	}
+	name := self.newNonterm(rule+"_group", field)
+	for _, alt := range alts {
+		alt.name = name
+		alt.code = passValue(field, len(alt.body))
+		*helpers = append(*helpers, alt)
+	}
+	return name, nil
+}
+
+// lowerRepeat writes the helper rules of `sym?`, `sym*` and `sym+` and
+// returns the helper's name. An option has the type of sym, repetitions a
+// slice of it, built right to left so top-down parsers can use them too:
+//
+//	A_opt   :             { }
+//	        | sym         { $$ = $1 }
+//	A_list  : sym A_list  { $$ = append([]T{$1}, $2...) }
+//	        |             { }
+//	A_list2 : sym A_list  { $$ = append([]T{$1}, $2...) }
+func (self *Grammar) lowerRepeat(rule, sym string, op WordTok, helpers *[]Production) string {
+	field := self.symbolType(sym)
+	if op.text == "?" {
+		name := self.newNonterm(rule+"_opt", field)
+		*helpers = append(*helpers,
+			Production{name: name, body: []string{}, code: "{ }", pos: op.pos},
+			Production{name: name, body: []string{sym}, code: passValue(field, 1), pos: op.pos})
+		return name
+	}
+
+	listField, code := "", "{ }"
+	if len(field) > 0 {
+		listField = self.listField(field)
+		code = fmt.Sprintf("{ $$ = append([]%s{$1}, $2...) }", self.unionTypes[field])
+	}
+	list := self.newNonterm(rule+"_list", listField)
+	*helpers = append(*helpers,
+		Production{name: list, body: []string{sym, list}, code: code, pos: op.pos},
+		Production{name: list, body: []string{}, code: "{ }", pos: op.pos})
+	if op.text == "*" {
+		return list
+	}
+	plus := self.newNonterm(rule+"_list", listField)
+	*helpers = append(*helpers, Production{name: plus, body: []string{sym, list}, code: code, pos: op.pos})
+	return plus
+}
+
+// passValue is the action of a helper rule of n symbols: the value of the
+// only one if the rule has a type, nothing otherwise. Helper rules never
+// take %defaultcode.
+func passValue(field string, n int) string {
+	if len(field) == 0 || n != 1 {
+		return "{ }"
+	}
+	return copyValue(field, 1)
+}
+
+// listField returns the union field holding a slice of field's type,
+// declaring it if needed.
+func (self *Grammar) listField(field string) string {
+	name, typ := field+"_list", "[]"+self.unionTypes[field]
+	for i := 2; ; i++ {
+		if t, b := self.unionTypes[name]; !b || t == typ {
+			break
+		}
+		name = fmt.Sprintf("%s_list%d", field, i)
+	}
+	self.unionTypes[name] = typ
+	return name
+}
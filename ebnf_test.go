@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+const repetitions = `
+%union {
+    n int
+}
+%token<n> num neg
+%type<n> List Item
+%%
+List : Item+ (';' | ',')? { $$ = sum($1) }
+     ;
+Item : num { $$ = $1 }
+     | '(' (Item | neg)* ')' { $$ = sum($2) }
+     ;`
+
+func TestParseEBNF(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, repetitions)
+	var buf bytes.Buffer
+	if err := grammar.DumpGrammar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `%type<n> List Item Item_group
+%type<n_list> List_list List_list2 Item_list
+
+%%
+
+List : List_list2 List_opt    { $$ = sum($1) }
+     ;
+
+List_list : Item List_list    { $$ = append([]int{$1}, $2...) }
+          |    { }
+          ;
+
+List_list2 : Item List_list    { $$ = append([]int{$1}, $2...) }
+           ;
+
+List_group : ';'    { }
+           | ','    { }
+           ;
+
+List_opt :    { }
+         | List_group    { }
+         ;
+
+Item : num    { $$ = $1 }
+     | '(' Item_list ')'    { $$ = sum($2) }
+     ;
+
+Item_group : Item    { $$ = $1 }
+           | neg    { $$ = $1 }
+           ;
+
+Item_list : Item_group Item_list    { $$ = append([]int{$1}, $2...) }
+          |    { }
+          ;
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+	if grammar.unionTypes["n_list"] != "[]int" {
+		t.Errorf("Expected a []int union field for the lists, got %v", grammar.unionTypes)
+	}
+
+	for input, ok := range map[string]bool{
+		"num":                   true,
+		"num num ;":             true,
+		"( num neg ) ( ( ) ) ,": true,
+		"; num":                 false,
+		"num ; num":             false,
+	} {
+		if _, err := grammar.Parse("", strings.Fields(input)); (err == nil) != ok {
+			t.Errorf("Expected %s to be accepted: %v, got %v", input, ok, err)
+		}
+	}
+}
+
+func TestParsersEBNF(t *testing.T) {
+	grammar := "%package main\n" + repetitions + "\n%%\nfunc sum(values []int) int { return len(values) }\n"
+	// the helper rules suit top-down parsers as well
+	for _, backend := range []func(in, out *os.File) error{(&Generator{}).LLParser, (&Generator{}).LRParser} {
+		output, err := generateWith(t, backend, grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(output), "n_list []int") {
+			t.Errorf("Expected a slice field in yytype")
+		}
+	}
+}
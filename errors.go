@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GrammarError points at the place in the grammar file that could not be
+// processed.
+type GrammarError struct {
+	Position
+	Msg string
+}
+
+func (self *GrammarError) Error() string {
+	return fmt.Sprintf("%s: %s", self.Position, self.Msg)
+}
+
+// ConflictError is returned when the parse table has conflicts and
+// the Generator's ConflictPolicy is FailOnConflict. Table names the kind of
+// table, LL(K) if empty. MinK is the lookahead that would remove the
+// conflicts of an LL table, 0 if none was found.
+type ConflictError struct {
+	Conflicts []Conflict
+	Table     string
+	K         int
+	MinK      int
+}
+
+func (self *ConflictError) Error() string {
+	table := self.Table
+	if len(table) == 0 {
+		table = fmt.Sprintf("LL(%d)", max(self.K, 1))
+	}
+	header := fmt.Sprintf("grammar is not %s: %d conflicts", table, len(self.Conflicts))
+	if self.MinK > 0 {
+		header += fmt.Sprintf(", it is LL(%d)", self.MinK)
+	}
+	lines := make([]string, 0, len(self.Conflicts)+1)
+	lines = append(lines, header)
+	for _, conflict := range self.Conflicts {
+		lines = append(lines, "\t"+conflict.String())
+	}
+	return strings.Join(lines, "\n")
+}
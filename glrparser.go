@@ -0,0 +1,286 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// GLRParser reads a grammar from in and writes a GLR parser to out. Where
+// the table built by LRMethod has conflicts, the parser follows every action
+// on a graph-structured stack and packs the derivations it finds into a
+// shared parse forest. Once the input is read the actions run on one
+// derivation: the first production of an ambiguous symbol, unless the user
+// code sets yyambiguity to pick another one. Syntax errors are returned as
+// by LRParser.
+func (self *Generator) GLRParser(in *os.File, out *os.File) error {
+	grammar, scanner, err := self.loadGrammar(in)
+	if err != nil {
+		return err
+	}
+
+	mergedSymbols := grammar.MergeSymbols()
+	table, conflicts := ComputeLRTable(self.LRMethod, grammar.prods, mergedSymbols, grammar.precs, grammar.maxToken, grammar.startSymbols()...)
+	for _, conflict := range conflicts {
+		parserLog("Kept for the GLR parser: %s", conflict)
+	}
+
+	return writeParser(scanner, in, out, func(buf *bytes.Buffer, srcName, outName string) {
+		grammar.printGLRFile(table, mergedSymbols, srcName, outName, buf)
+	})
+}
+
+func (self *Grammar) printGLRFile(table *LRTable,
+	tokens map[string]int,
+	srcName, outName string,
+	out *bytes.Buffer) {
+	self.printPrologue("A GLR Grammar Parser", out)
+	self.printLRTables(table, tokens, out)
+
+	// the actions left out of yyaction, by state and word
+	keys := make([][2]int, 0, len(table.Dropped))
+	for key := range table.Dropped {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	out.WriteString("var yydropped = map[[2]int][]int{\n")
+	for _, key := range keys {
+		out.WriteString(fmt.Sprintf("\t{%d, %d}: %s,\n", key[0], key[1], intSlice(table.Dropped[key])))
+	}
+	out.WriteString("}\n\n")
+
+	self.printCharmap(tokens, out)
+	self.printRuncode(srcName, outName, out)
+	self.printLREntryPoints(out)
+
+	out.WriteString(`// yysppf is a node of the shared packed parse forest: a symbol deriving
+// the words [start, end) in every way listed in alts. Words have a value,
+// a span and no alts.
+type yysppf struct {
+    sym        int
+    start, end int
+    value      *yytype
+    span       Span
+    alts       []*yyalt
+}
+
+// yyalt is one derivation of a forest node.
+type yyalt struct {
+    prod int
+    kids []*yysppf
+}
+
+// yyambiguity picks the derivation of an ambiguous symbol named name the
+// actions run on. The alternatives are sorted by production; an index out of
+// their range keeps the first one.
+var yyambiguity func(name string, alts []*yyalt) int
+
+func (node *yysppf) add(prod int, kids []*yysppf) {
+    at := len(node.alts)
+    for i, alt := range node.alts {
+        if alt.prod == prod && len(alt.kids) == len(kids) {
+            same := true
+            for n := range kids {
+                same = same && alt.kids[n] == kids[n]
+            }
+            if same {
+                return
+            }
+        }
+        if alt.prod > prod && at == len(node.alts) {
+            at = i
+        }
+    }
+    node.alts = append(node.alts, nil)
+    copy(node.alts[at+1:], node.alts[at:])
+    node.alts[at] = &yyalt{prod, kids}
+}
+
+// yygss is a node of the graph-structured stack, reached after pos words.
+type yygss struct {
+    state int
+    pos   int
+    links []*yylink
+}
+
+// yylink leads to the node below, node being the symbol between them.
+type yylink struct {
+    to   *yygss
+    node *yysppf
+}
+
+func yyactions(state, word int) []int {
+    acts := yydropped[[2]int{state, word}]
+    if act := yyaction[state][word]; act != 0 {
+        acts = append([]int{act}, acts...)
+    }
+    return acts
+}
+
+// yypaths calls visit with the node n links below node and the forest
+// nodes along the way. If through is set only the paths using it count.
+func yypaths(node *yygss, n int, through *yylink, kids []*yysppf, visit func(*yygss, []*yysppf)) {
+    if n == 0 {
+        if through == nil {
+            visit(node, kids)
+        }
+        return
+    }
+    for _, link := range node.links {
+        next := through
+        if link == through {
+            next = nil
+        }
+        yypaths(link.to, n-1, next, append([]*yysppf{link.node}, kids...), visit)
+    }
+}
+
+// yyglr reads every word and returns the forest node of the symbol parsed
+// from state start.
+func yyglr(start int, nextWord func() (bool, string, *yytype, Span)) (*yysppf, error) {
+    tops := []*yygss{{state: start}}
+    nodes := make(map[[3]int]*yysppf)
+    // the words any stack could take, for syntax errors
+    expects := func(idx int) bool {
+        for _, top := range tops {
+            if len(yyactions(top.state, idx)) > 0 {
+                return true
+            }
+        }
+        return false
+    }
+    for pos := 0; ; pos++ {
+        eof, word, yyval, span := nextWord()
+        if eof {
+            word = "$"
+        }
+        wordIdx := word2Idx(word)
+        if wordIdx < 0 {
+            return nil, SyntaxErrors{yysyntaxError(pos, span, word, wordIdx, expects)}
+        }
+
+        // reduce until no new node or link shows up; a new link may open
+        // paths for the reductions already done
+        type reduction struct {
+            node    *yygss
+            through *yylink
+        }
+        work := make([]reduction, 0)
+        for _, top := range tops {
+            work = append(work, reduction{top, nil})
+        }
+        var accepted *yysppf
+        for len(work) > 0 {
+            r := work[0]
+            work = work[1:]
+            for _, act := range yyactions(r.node.state, wordIdx) {
+                if act <= yyaccept {
+                    accepted = r.node.links[0].node
+                    continue
+                }
+                if act > 0 || (yylen[-act-1] == 0 && r.through != nil) {
+                    continue
+                }
+                prod := -act - 1
+                yypaths(r.node, yylen[prod], r.through, nil, func(base *yygss, kids []*yysppf) {
+                    key := [3]int{yylhs[prod], base.pos, pos}
+                    node, b := nodes[key]
+                    if !b {
+                        node = &yysppf{sym: yylhs[prod], start: base.pos, end: pos}
+                        nodes[key] = node
+                    }
+                    node.add(prod, kids)
+
+                    state := yygoto[base.state][yylhs[prod]-MAXTOKEN-1]
+                    var target *yygss
+                    for _, top := range tops {
+                        if top.state == state {
+                            target = top
+                        }
+                    }
+                    if target == nil {
+                        target = &yygss{state: state, pos: pos, links: []*yylink{{base, node}}}
+                        tops = append(tops, target)
+                        work = append(work, reduction{target, nil})
+                        return
+                    }
+                    for _, link := range target.links {
+                        if link.to == base {
+                            return
+                        }
+                    }
+                    link := &yylink{base, node}
+                    target.links = append(target.links, link)
+                    for _, top := range tops {
+                        work = append(work, reduction{top, link})
+                    }
+                })
+            }
+        }
+        if accepted != nil {
+            return accepted, nil
+        }
+
+        leaf := &yysppf{sym: wordIdx, start: pos, end: pos + 1, value: yyval, span: span}
+        shifted := make([]*yygss, 0)
+        for _, top := range tops {
+            for _, act := range yyactions(top.state, wordIdx) {
+                if act <= 0 {
+                    continue
+                }
+                var target *yygss
+                for _, node := range shifted {
+                    if node.state == act-1 {
+                        target = node
+                    }
+                }
+                if target == nil {
+                    target = &yygss{state: act - 1, pos: pos + 1}
+                    shifted = append(shifted, target)
+                }
+                target.links = append(target.links, &yylink{top, leaf})
+            }
+        }
+        if len(shifted) == 0 {
+            return nil, SyntaxErrors{yysyntaxError(pos, span, word, wordIdx, expects)}
+        }
+        tops = shifted
+    }
+}
+
+// yyeval runs the actions of the chosen derivation of node and pushes its
+// value and span.
+func yyeval(node *yysppf, values *Stack[*yytype], spans *Stack[Span]) {
+    if node.alts == nil {
+        values.push(node.value)
+        spans.push(node.span)
+        return
+    }
+    alt := node.alts[0]
+    if len(node.alts) > 1 && yyambiguity != nil {
+        if i := yyambiguity(yynames[node.sym], node.alts); i >= 0 && i < len(node.alts) {
+            alt = node.alts[i]
+        }
+    }
+    for _, kid := range alt.kids {
+        yyeval(kid, values, spans)
+    }
+    yyruncode(alt.prod, values, spans)
+}
+
+// yyparse parses from state start the words nextWord returns.
+func yyparse(start int, nextWord func() (bool, string, *yytype, Span)) (*yytype, error) {
+    root, err := yyglr(start, nextWord)
+    if err != nil {
+        return nil, err
+    }
+    values := NewStack[*yytype]()
+    yyeval(root, values, NewStack[Span]())
+    return values.pop(), nil
+}
+
+`)
+}
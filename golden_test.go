@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenBackend is a kind of parser the golden tests run.
+type goldenBackend struct {
+	name    string
+	backend func(in, out *os.File) error
+}
+
+// TestGoldenParsers generates every kind of parser from input.y, runs it on
+// testdata/input.txt and compares what it prints with testdata/input.golden.
+func TestGoldenParsers(t *testing.T) {
+	checkGolden(t, "input.y", "input", []goldenBackend{
+		{"LL", (&Generator{}).LLParser},
+		{"LR", (&Generator{}).LRParser},
+		{"GLR", (&Generator{}).GLRParser},
+		{"typed LR", (&Generator{TypedValues: true}).LRParser},
+	})
+}
+
+// TestGoldenSpans runs the parsers of testdata/spans.y, whose actions print
+// the spans of the symbols, on testdata/spans.txt and compares what they
+// print with testdata/spans.golden.
+func TestGoldenSpans(t *testing.T) {
+	checkGolden(t, filepath.Join("testdata", "spans.y"), "spans", []goldenBackend{
+		{"LR", (&Generator{}).LRParser},
+		{"LL", (&Generator{EliminateLeftRecursion: true}).LLParser},
+		{"GLR", (&Generator{}).GLRParser},
+		{"typed LR", (&Generator{TypedValues: true}).LRParser},
+	})
+}
+
+// TestGeneratedNonterminalWords feeds the parsers of testdata/spans.y words
+// spelled like its nonterminals, which are syntax errors as any unknown word.
+func TestGeneratedNonterminalWords(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("running the generated parsers needs the go tool")
+	}
+	grammar := filepath.Join("testdata", "spans.y")
+	expected := `1:5: expected id but got "E"
+1:5: expected id but got "T"
+1:1: expected id but got "E"
+`
+	for _, b := range []goldenBackend{
+		{"LR", (&Generator{}).LRParser},
+		{"LL", (&Generator{EliminateLeftRecursion: true}).LLParser},
+		{"GLR", (&Generator{}).GLRParser},
+		{"typed LR", (&Generator{TypedValues: true}).LRParser},
+	} {
+		if output := runGenerated(t, gobin, b.backend, grammar, "a + E\na + T\nE\n"); output != expected {
+			t.Errorf("%s parser: expected:\n%s\nGot:\n%s", b.name, expected, output)
+		}
+	}
+}
+
+// checkGolden runs the parser every backend generates from grammar on
+// testdata/name.txt and compares what it prints with testdata/name.golden,
+// which -update rewrites with the output of the first one.
+func checkGolden(t *testing.T, grammar, name string, backends []goldenBackend) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("running the generated parsers needs the go tool")
+	}
+	input := readFile(t, filepath.Join("testdata", name+".txt"))
+	golden := filepath.Join("testdata", name+".golden")
+	for i, b := range backends {
+		output := runGenerated(t, gobin, b.backend, grammar, input)
+		if *update && i == 0 {
+			if err := ioutil.WriteFile(golden, []byte(output), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected := readFile(t, golden); output != expected {
+			t.Errorf("%s parser: expected:\n%s\nGot:\n%s", b.name, expected, output)
+		}
+	}
+}
+
+// runGenerated writes the parser backend generates from grammar to a module
+// of its own and returns what it prints on input.
+func runGenerated(t *testing.T, gobin string, backend func(in, out *os.File) error, grammar, input string) string {
+	dir := t.TempDir()
+	in, err := os.Open(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(dir, "yy.output.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend(in, out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module calc\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Running the parser: %v\n%s", err, output)
+	}
+	return string(output)
+}
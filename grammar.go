@@ -1,120 +1,205 @@
 package parser
 
 import (
-	"os"
+	"fmt"
 	"strings"
 )
 
-func ParseHeaders(scanner *Scanner) {
-	for err, word := scanner.NextWord(); err == nil; err, word = scanner.NextWord() {
+func (self *Grammar) ParseHeaders(scanner *Scanner) error {
+	err, word := scanner.NextWord()
+	for ; err == nil; err, word = scanner.NextWord() {
 		if word.tokType == separate {
 			break
 		}
 		if word.tokType == newline {
 			continue
 		}
-		if word.tokType == hfield {
-			switch word.text {
-			case "%package":
-				err, name := scanner.NextWord()
-				if err != nil {
-					parserLog("Parse `package` error: %s", err.Error())
-					os.Exit(1)
-				}
-				packagename = name.text
-			case "%defaultcode":
-				err, tcode := scanner.NextWord()
-				if err != nil || tcode.tokType != code {
-					parserLog("Parse `defaultcode` error: %s", err.Error())
-					os.Exit(1)
-				}
-				defaultcode = tcode.text
-			case "%import":
-				parseModules(scanner)
-			case "%union":
-				parseUnionTypes(scanner)
-			default:
-				if strings.Index(word.text, "%token") == 0 {
-					parseSymbolTypes(termTypes, word.text[7:len(word.text)-1], scanner)
-				}
-				if strings.Index(word.text, "%type") == 0 {
-					parseSymbolTypes(nontermTypes, word.text[6:len(word.text)-1], scanner)
-				}
-			}
-		}
-	}
-}
-
-func ParseGrammars(scanner *Scanner) {
-	for err, word := scanner.NextWord(); err == nil && word.tokType != separate; err, word = scanner.NextWord() {
+		if word.tokType != hfield {
+			return scanner.wordError(word, "unexpected %q in headers", word.text)
+		}
+		switch word.text {
+		case "%package":
+			err, name := scanner.NextWord()
+			if err != nil {
+				return headerError(scanner, err, word)
+			}
+			if name.tokType != term && name.tokType != nonterm {
+				return scanner.wordError(name, "expected package name after %%package, got %q", name.text)
+			}
+			self.packagename = name.text
+		case "%defaultcode":
+			err, tcode := scanner.NextWord()
+			if err != nil {
+				return headerError(scanner, err, word)
+			}
+			if tcode.tokType != code {
+				return scanner.wordError(tcode, "expected code block after %%defaultcode, got %q", tcode.text)
+			}
+			self.defaultcode = tcode.text
+			self.defaultPos = tcode.pos
+		case "%import":
+			if err := self.parseModules(scanner); err != nil {
+				return err
+			}
+		case "%union":
+			if err := self.parseUnionTypes(scanner); err != nil {
+				return err
+			}
+		case "%start":
+			if err := self.parseStarts(scanner, word); err != nil {
+				return err
+			}
+		case "%skip":
+			if err := self.parseSkips(scanner, word); err != nil {
+				return err
+			}
+		case "%left", "%right", "%nonassoc":
+			if err := self.parsePrecedence(scanner, word); err != nil {
+				return err
+			}
+		default:
+			var err error
+			if strings.Index(word.text, "%token") == 0 {
+				err = self.parseSymbolTypes(self.termTypes, "%token", word, scanner)
+			} else if strings.Index(word.text, "%type") == 0 {
+				err = self.parseSymbolTypes(self.nontermTypes, "%type", word, scanner)
+			} else {
+				err = scanner.wordError(word, "unknown header field %s", word.text)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err != nil && err != errEOF {
+		return err
+	}
+	return nil
+}
+
+func (self *Grammar) ParseGrammars(scanner *Scanner) error {
+	err, word := scanner.NextWord()
+	for ; err == nil && word.tokType != separate; err, word = scanner.NextWord() {
 		if word.tokType == newline {
 			continue
 		}
-		eatSymbol(&word)
-		production := Production{name: word.text}
-		parserLog("Parsing Grammar: %s", word.text)
+		if word.tokType != nonterm {
+			return scanner.wordError(word, "expected nonterminal at start of rule, got %q", word.text)
+		}
+		self.eatSymbol(&word)
+		name := word.text
+		parserLog("Parsing Grammar: %s", name)
 
-		startGrammar := true
-		for err, word = scanner.NextWord(); err == nil && word.tokType != enddef; err, word = scanner.NextWord() {
-			if startGrammar && word.tokType != begindef {
-				parserLog("Expected grammar begin def in grammar %s", production.name)
-				os.Exit(1)
-			}
-			if !startGrammar && word.tokType != alternate {
-				parserLog("Expected alternate after first rule in grammar %s", production.name)
-				os.Exit(1)
+		err, word = scanner.NextWord()
+		if err != nil {
+			return ruleError(scanner, err, name)
+		}
+		if word.tokType != begindef {
+			return scanner.wordError(word, "expected ':' after %s, got %q", name, word.text)
+		}
+		helpers := make([]Production, 0)
+		for start := word; ; {
+			production := Production{name: name, pos: start.pos}
+			end, err := self.parseGrammarBody(scanner, &production, &helpers)
+			if err != nil {
+				return ruleError(scanner, err, name)
 			}
-			if startGrammar {
-				startGrammar = !startGrammar
+			if err := self.resolveActionRefs(&production); err != nil {
+				return err
 			}
+			self.prods = append(self.prods, production)
 
-			body, bodyCode := parseGrammarBody(scanner)
-			production.body = body
-			production.code = bodyCode
-			prods = append(prods, production)
+			// an alternative may be closed by a newline, then `|` or `;`
+			// must follow on a later line
+			for err == nil && end.tokType == newline {
+				err, end = scanner.NextWord()
+			}
+			if err != nil {
+				return ruleError(scanner, err, name)
+			}
+			if end.tokType == enddef {
+				self.prods = append(self.prods, helpers...)
+				break
+			}
+			if end.tokType != alternate {
+				return scanner.wordError(end, "expected '|' or ';' in rule %s, got %q", name, end.text)
+			}
+			start = end
+		}
+	}
+	if err != nil && err != errEOF {
+		return err
+	}
+	for i, start := range self.starts {
+		found := false
+		for _, prod := range self.prods {
+			found = found || prod.name == start
+		}
+		if !found {
+			return &GrammarError{Position: self.startPos[i], Msg: fmt.Sprintf("start symbol %s has no rules", start)}
 		}
 	}
+	return self.checkDefaultCode()
 }
 
-func MergeSymbols(literals map[string]int, tokens map[string]int, symbols map[string]int) map[string]int {
+// MergeSymbols numbers every symbol of the grammar: "" is 0, "$" is 1, then
+// literals, tokens up to maxToken and nonterminals after them.
+func (self *Grammar) MergeSymbols() map[string]int {
 	merged := make(map[string]int)
 	merged[""] = 0
 	merged["$"] = 1
-	MINTOKEN, MAXTOKEN = 0, 0
-	for lit, id := range literals {
+	// ids of each set are dense, so the ranges stay apart even when a
+	// grammar has no literals or no tokens
+	self.minToken = len(self.literalSet) + 2
+	self.maxToken = self.minToken + len(self.tokenSet) - 1
+	for lit, id := range self.literalSet {
 		merged[lit] = id + 2
-		if MINTOKEN < id+2 {
-			MINTOKEN = id + 2
-		}
 	}
-	MINTOKEN += 1
-	for tok, id := range tokens {
-		merged[tok] = MINTOKEN + id
-		if MAXTOKEN < MINTOKEN+id {
-			MAXTOKEN = MINTOKEN + id
-		}
+	for tok, id := range self.tokenSet {
+		merged[tok] = self.minToken + id
 	}
-	for sym, id := range symbols {
-		merged[sym] = MAXTOKEN + id + 1
+	for sym, id := range self.symbolSet {
+		merged[sym] = self.maxToken + id + 1
 	}
 	return merged
 }
 
-func parseModules(scanner *Scanner) {
-	for err, word := scanner.NextWord(); err == nil && word.tokType != newline; err, word = scanner.NextWord() {
-		if word.tokType == newline {
-			continue
-		}
+// headerError turns an error hit while reading the argument of a header
+// field into a GrammarError.
+func headerError(scanner *Scanner, err error, field WordTok) error {
+	if err == errEOF {
+		return scanner.errorf(len(scanner.content), "unexpected end of file after %s", field.text)
+	}
+	return err
+}
+
+// ruleError turns an error hit inside a rule into a GrammarError.
+func ruleError(scanner *Scanner, err error, name string) error {
+	if err == errEOF {
+		return scanner.errorf(len(scanner.content), "unexpected end of file in rule %s, missing ';'", name)
+	}
+	return err
+}
+
+func (self *Grammar) parseModules(scanner *Scanner) error {
+	err, word := scanner.NextWord()
+	for ; err == nil && word.tokType != newline; err, word = scanner.NextWord() {
 		moduleName := word.text
-		modules = append(modules, moduleName)
+		self.modules = append(self.modules, moduleName)
+	}
+	if err != nil && err != errEOF {
+		return err
 	}
+	return nil
 }
 
-func parseUnionTypes(scanner *Scanner) {
+func (self *Grammar) parseUnionTypes(scanner *Scanner) error {
 	err, text := scanner.NextWord()
-	if err != nil || text.tokType != code {
-		parserLog("Parse `union` error: %s", err.Error())
-		os.Exit(1)
+	if err != nil {
+		return headerError(scanner, err, WordTok{text: "%union"})
+	}
+	if text.tokType != code {
+		return scanner.wordError(text, "expected code block after %%union, got %q", text.text)
 	}
 	code_text := strings.Trim(text.text, " \n")
 	code_text = code_text[1 : len(code_text)-1]
@@ -128,61 +213,235 @@ func parseUnionTypes(scanner *Scanner) {
 		for err, typeTok := codeScanner.NextWord(); err == nil && typeTok.tokType != newline; err, typeTok = codeScanner.NextWord() {
 			vType += typeTok.text
 		}
+		if len(vType) == 0 {
+			return scanner.wordError(text, "missing type for %%union field %s", word.text)
+		}
 		tname := word.text
-		unionTypes[tname] = vType
+		self.unionTypes[tname] = vType
 	}
+	return nil
 }
 
-func parseSymbolTypes(symTbl map[string]string, typeName string, scanner *Scanner) {
-	for err, word := scanner.NextWord(); err == nil && word.tokType != newline; err, word = scanner.NextWord() {
+// parseSymbolTypes reads `%token<field> sym...` and `%type<field> sym...`
+// lines and records the union field of every symbol. A %token symbol may be
+// followed by the /regexp/ the generated lexer matches it with, and then
+// the field may be left out.
+func (self *Grammar) parseSymbolTypes(symTbl map[string]string, prefix string, field WordTok, scanner *Scanner) error {
+	tag := field.text[len(prefix):]
+	typeName := ""
+	if len(tag) > 0 || prefix != "%token" {
+		if len(tag) < 3 || tag[0] != '<' || tag[len(tag)-1] != '>' {
+			return scanner.wordError(field, "expected %s<field>, got %s", prefix, field.text)
+		}
+		typeName = tag[1 : len(tag)-1]
+	}
+	last := WordTok{}
+	err, word := scanner.NextWord()
+	for ; err == nil && word.tokType != newline; err, word = scanner.NextWord() {
+		if word.tokType == pattern {
+			if prefix != "%token" || last.tokType != term {
+				return scanner.wordError(word, "pattern %s does not follow a token", word.text)
+			}
+			if err := self.addPattern(last.text, word); err != nil {
+				return err
+			}
+			last = word
+			continue
+		}
 		parserLog("Symbol %s", word.text)
 		symName := word.text
-		symTbl[symName] = typeName
+		if len(typeName) > 0 {
+			symTbl[symName] = typeName
+		}
+		last = word
+	}
+	if err != nil && err != errEOF {
+		return err
+	}
+	return nil
+}
+
+// parseSkips reads a `%skip /regexp/...` line, the text the generated
+// lexer leaves out between words.
+func (self *Grammar) parseSkips(scanner *Scanner, field WordTok) error {
+	err, word := scanner.NextWord()
+	count := 0
+	for ; err == nil && word.tokType != newline; err, word = scanner.NextWord() {
+		if word.tokType != pattern {
+			return scanner.wordError(word, "expected pattern after %%skip, got %q", word.text)
+		}
+		if err := self.addPattern("", word); err != nil {
+			return err
+		}
+		count++
+	}
+	if err != nil && err != errEOF {
+		return err
+	}
+	if count == 0 {
+		return scanner.wordError(field, "expected pattern after %%skip")
+	}
+	return nil
+}
+
+// parseStarts reads a `%start sym...` line. The first symbol is the one
+// yyparser parses, every one gets a yyparse function of its own.
+func (self *Grammar) parseStarts(scanner *Scanner, field WordTok) error {
+	err, word := scanner.NextWord()
+	for ; err == nil && word.tokType != newline; err, word = scanner.NextWord() {
+		if word.tokType != nonterm {
+			return scanner.wordError(word, "expected nonterminal after %%start, got %q", word.text)
+		}
+		for _, start := range self.starts {
+			if start == word.text {
+				return scanner.wordError(word, "start symbol %s named twice", word.text)
+			}
+		}
+		self.starts = append(self.starts, word.text)
+		self.startPos = append(self.startPos, word.pos)
+	}
+	if err != nil && err != errEOF {
+		return err
+	}
+	if len(self.starts) == 0 {
+		return scanner.wordError(field, "expected nonterminal after %%start")
+	}
+	return nil
+}
+
+// startSymbols returns the symbols named by %start, or the first rule's.
+func (self *Grammar) startSymbols() []string {
+	return startSymbols(self.prods, self.starts)
+}
+
+// parsePrecedence reads a `%left sym...` line, and %right and %nonassoc
+// alike; every line binds tighter than the ones before.
+func (self *Grammar) parsePrecedence(scanner *Scanner, field WordTok) error {
+	prec := Precedence{Level: 1, Assoc: LeftAssoc}
+	for _, p := range self.precs {
+		prec.Level = max(prec.Level, p.Level+1)
+	}
+	switch field.text {
+	case "%right":
+		prec.Assoc = RightAssoc
+	case "%nonassoc":
+		prec.Assoc = NonAssoc
+	}
+	err, word := scanner.NextWord()
+	for ; err == nil && word.tokType != newline; err, word = scanner.NextWord() {
+		if word.tokType != term && word.tokType != literal {
+			return scanner.wordError(word, "expected token after %s, got %q", field.text, word.text)
+		}
+		if _, b := self.precs[word.text]; b {
+			return scanner.wordError(word, "precedence of %s declared twice", word.text)
+		}
+		self.eatSymbol(&word)
+		self.precs[word.text] = prec
+	}
+	if err != nil && err != errEOF {
+		return err
 	}
+	return nil
 }
 
-func parseGrammarBody(scanner *Scanner) ([]string, string) {
-	body := make([]string, 0)
-	bodyCode := ""
-Loop:
+// parseGrammarBody reads one alternative of a rule into production and
+// returns the word that ended it. The rules made up for EBNF items and
+// actions in the middle of the body are added to helpers.
+func (self *Grammar) parseGrammarBody(scanner *Scanner, production *Production, helpers *[]Production) (WordTok, error) {
+	production.body = make([]string, 0)
 	for {
 		err, word := scanner.NextWord()
 		if err != nil {
-			parserLog("Parsing grammar body error: %s", err.Error())
-			os.Exit(1)
+			return word, err
+		}
+		if len(production.code) > 0 && (word.tokType == code || isItemStart(word.tokType)) {
+			if err := self.lowerMidAction(production, helpers); err != nil {
+				return word, err
+			}
 		}
 		switch word.tokType {
-		case nonterm:
-			fallthrough
-		case term:
-			fallthrough
-		case literal:
-			eatSymbol(&word)
-			body = append(body, word.text)
+		case nonterm, term, literal, lparen:
+			sym, alias, err := self.parseItem(scanner, production.name, word, helpers)
+			if err != nil {
+				return word, err
+			}
+			production.body = append(production.body, sym)
+			production.aliases = append(production.aliases, alias)
+			production.bodyPos = append(production.bodyPos, word.pos)
 		case code:
-			bodyCode = word.text
+			production.code = word.text
+			production.codePos = word.pos
+		case hfield:
+			if word.text != "%prec" {
+				return word, scanner.wordError(word, "unexpected %q in rule body", word.text)
+			}
+			err, sym := scanner.NextWord()
+			if err != nil {
+				return sym, err
+			}
+			if _, b := self.precs[sym.text]; !b {
+				return sym, scanner.wordError(sym, "%%prec %s has no precedence", sym.text)
+			}
+			production.prec = sym.text
+		case newline, alternate, enddef:
+			parserLog("Body: %v", production.body)
+			return word, nil
 		default:
-			break Loop
+			return word, scanner.wordError(word, "unexpected %q in rule body", word.text)
+		}
+	}
+}
+
+func isItemStart(tokType TokType) bool {
+	return tokType == nonterm || tokType == term || tokType == literal || tokType == lparen
+}
+
+// lowerMidAction moves the action read so far in production into an empty
+// helper rule, which takes its place in the body. Its values are those left
+// of the helper, so references to them are made relative to it; the helper
+// has the type of the first $<field>$ of the action, if any.
+func (self *Grammar) lowerMidAction(production *Production, helpers *[]Production) error {
+	before := *production
+	if err := self.resolveActionRefs(&before); err != nil {
+		return err
+	}
+	field := ""
+	for _, ref := range findActionRefs(before.code) {
+		if ref.lhs && len(ref.tag) > 0 {
+			field = ref.tag
+			break
 		}
 	}
-	parserLog("Body: %v", body)
-	return body, bodyCode
+	n := len(before.body)
+	name := self.newNonterm(production.name+"_act", field)
+	*helpers = append(*helpers, Production{
+		name:    name,
+		body:    []string{},
+		code:    self.moveActionRefs(before.code, before.body, func(i int) int { return i - n }),
+		codePos: before.codePos,
+		pos:     before.codePos,
+	})
+	production.body = append(production.body, name)
+	production.bodyPos = append(production.bodyPos, before.codePos)
+	production.aliases = append(production.aliases, "")
+	production.code = ""
+	return nil
 }
 
-func eatSymbol(word *WordTok) {
+func (self *Grammar) eatSymbol(word *WordTok) {
 	parserLog("Eating {%s, %s}", word.text, type2Str(word.tokType))
 	switch word.tokType {
 	case literal:
-		if _, b := literalSet[word.text]; !b {
-			literalSet[word.text] = len(literalSet)
+		if _, b := self.literalSet[word.text]; !b {
+			self.literalSet[word.text] = len(self.literalSet)
 		}
 	case nonterm:
-		if _, b := symbolSet[word.text]; !b {
-			symbolSet[word.text] = len(symbolSet)
+		if _, b := self.symbolSet[word.text]; !b {
+			self.symbolSet[word.text] = len(self.symbolSet)
 		}
 	case term:
-		if _, b := tokenSet[word.text]; !b {
-			tokenSet[word.text] = len(tokenSet)
+		if _, b := self.tokenSet[word.text]; !b {
+			self.tokenSet[word.text] = len(self.tokenSet)
 		}
 	}
 }
@@ -1,10 +1,13 @@
 package parser
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
 func TestParseHeaders(t *testing.T) {
+	t.Parallel()
 	content := `%package main     # Set the package of the generated file to "main"
 
 %import scanner fmt os strconv  # import (
@@ -37,19 +40,18 @@ func TestParseHeaders(t *testing.T) {
 
 # Associate the "MultA" and "AddA" nonterminals with the type of op func(float)float
 %type<op> MultA AddA`
-	unionTypes = make(map[string]string)
-	termTypes = make(map[string]string)
-	nontermTypes = make(map[string]string)
-	modules = make([]string, 0)
+	grammar := NewGrammar()
 
 	scanner := &Scanner{content: []byte(content), index: 0}
-	ParseHeaders(scanner)
+	if err := grammar.ParseHeaders(scanner); err != nil {
+		t.Fatal(err)
+	}
 
-	if packagename != "main" {
-		t.Errorf("Expected package name: main, Got: %s ", packagename)
+	if grammar.packagename != "main" {
+		t.Errorf("Expected package name: main, Got: %s ", grammar.packagename)
 	}
 	expectedModules := []string{"scanner", "fmt", "os", "strconv"}
-	for i, text := range modules {
+	for i, text := range grammar.modules {
 		if expectedModules[i] != text {
 			t.Errorf("Expected package: %s, Got: %s", expectedModules[i], text)
 		}
@@ -57,18 +59,18 @@ func TestParseHeaders(t *testing.T) {
 	expectedCode := `{
     fmt.Println("Default code. Assigning", $1, " to ", $$, "."); $$ = $1
 }`
-	if defaultcode != expectedCode {
-		t.Errorf("Expected default code:\n%s\n, Got:\n%s\n", expectedCode, defaultcode)
+	if grammar.defaultcode != expectedCode {
+		t.Errorf("Expected default code:\n%s\n, Got:\n%s\n", expectedCode, grammar.defaultcode)
 	}
 
 	expectedUnions := map[string]string{"fval": "float", "ival": "int", "op": "func(float)float"}
-	checkMap(expectedUnions, unionTypes, t)
+	checkMap(expectedUnions, grammar.unionTypes, t)
 
 	expectedTermTypes := map[string]string{
 		"floating": "fval",
 		"integer":  "ival",
 	}
-	checkMap(expectedTermTypes, termTypes, t)
+	checkMap(expectedTermTypes, grammar.termTypes, t)
 
 	expectedNontermTypes := map[string]string{
 		"Calc":  "fval",
@@ -78,10 +80,11 @@ func TestParseHeaders(t *testing.T) {
 		"MultA": "op",
 		"AddA":  "op",
 	}
-	checkMap(expectedNontermTypes, nontermTypes, t)
+	checkMap(expectedNontermTypes, grammar.nontermTypes, t)
 }
 
 func TestParseGrammar(t *testing.T) {
+	t.Parallel()
 	content := `Calc : Add        # This will use the code in %defaultcode
      ;
 
@@ -107,12 +110,11 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 
 %%`
 	scanner := &Scanner{content: []byte(content), index: 0}
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
+	grammar := NewGrammar()
 
-	ParseGrammars(scanner)
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
 
 	expectedLiterals := map[string]int{
 		"'*'": 0,
@@ -150,14 +152,14 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 		expectedProd10, expectedProd11,
 	}
 
-	checkMap2(expectedLiterals, literalSet, t)
-	checkMap2(expectedTokens, tokenSet, t)
-	checkMap2(expectedSymbols, symbolSet, t)
+	checkMap2(expectedLiterals, grammar.literalSet, t)
+	checkMap2(expectedTokens, grammar.tokenSet, t)
+	checkMap2(expectedSymbols, grammar.symbolSet, t)
 
-	if len(prods) != len(expectedProds) {
+	if len(grammar.prods) != len(expectedProds) {
 		t.Errorf("Production Parsing Error")
 	}
-	for i, prod := range prods {
+	for i, prod := range grammar.prods {
 		gotProd := prod2string(&prod)
 		parserLog("Got Production: %s", gotProd)
 		parserLog("Expected Production: %s", expectedProds[i])
@@ -166,7 +168,7 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 				expectedProds[i], gotProd)
 		}
 	}
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
+	mergedSymbols := grammar.MergeSymbols()
 	expectedMergedSymbols := map[string]int{
 		"":         0,
 		"$":        1,
@@ -184,14 +186,251 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 		"AddA":     13,
 	}
 	checkMap2(expectedMergedSymbols, mergedSymbols, t)
-	if MINTOKEN != 6 {
+	if grammar.minToken != 6 {
 		t.Errorf("Expected MINTOKEN to be 5")
 	}
-	if MAXTOKEN != 7 {
+	if grammar.maxToken != 7 {
 		t.Errorf("Expected MAXTOKEN to be 6")
 	}
 }
 
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		content string
+		err     string
+	}{
+		{"%package\n", "test.y:1:9: expected package name after %package, got \"\\n\""},
+		{"%defaultcode $$ = $1\n", "test.y:1:14: expected code block after %defaultcode, got \"$$\""},
+		{"%union {\n    fval float64\n", "test.y:1:8: unterminated code block"},
+		{"%union {\n    fval\n}\n", "test.y:1:8: missing type for %union field fval"},
+		{"%token<fval floating\n", "test.y:1:1: expected %token<field>, got %token<fval"},
+		{"%nosuch x\n", "test.y:1:1: unknown header field %nosuch"},
+		{"%token<n> /[0-9]+/\n", "test.y:1:11: pattern /[0-9]+/ does not follow a token"},
+		{"%token<n> num /[0-9]+/ /[a-z]+/\n", "test.y:1:24: pattern /[a-z]+/ does not follow a token"},
+		{"%type<n> Num /[0-9]+/\n", "test.y:1:14: pattern /[0-9]+/ does not follow a token"},
+		{"%token<n> num /[0-9]+/\n%token num /[0-9]/\n", "test.y:2:12: token num has a pattern already"},
+		{"%token num /(a/\n", "test.y:1:12: invalid pattern /(a/: missing closing ): `(a`"},
+		{"%token num /^[0-9]+/\n", "test.y:1:12: invalid pattern /^[0-9]+/: anchors are not supported"},
+		{"%skip /\\bx/\n", "test.y:1:7: invalid pattern /\\bx/: word boundaries are not supported"},
+		{"%skip\n", "test.y:1:1: expected pattern after %skip"},
+		{"%skip /[ ]+/ space\n", "test.y:1:14: expected pattern after %skip, got \"space\""},
+		{"%%\nCalc Add\n", "test.y:2:6: expected ':' after Calc, got \"Add\""},
+		{"%%\nCalc : Add\n     | Mult\n", "test.y:4:1: unexpected end of file in rule Calc, missing ';'"},
+		{"%%\nCalc : Add\n     Mult\n     ;\n", "test.y:3:6: expected '|' or ';' in rule Calc, got \"Mult\""},
+		{"%%\n'+' : Add\n", "test.y:2:1: expected nonterminal at start of rule, got \"'+'\""},
+		{"%%\nCalc : Add %nosuch\n", "test.y:2:12: unexpected \"%nosuch\" in rule body"},
+		{"%%\nCalc : (Add Mult\n     ;\n", "test.y:3:6: unexpected \";\" in group"},
+		{"%%\nCalc : (Add\n", "test.y:2:8: unclosed '(' in rule Calc"},
+		{"%%\nCalc : Add )\n     ;\n", "test.y:2:12: unexpected \")\" in rule body"},
+		{"%%\nCalc : * Add\n     ;\n", "test.y:2:8: unexpected \"*\" in rule body"},
+		{"%start\n", "test.y:1:1: expected nonterminal after %start"},
+		{"%start calc\n", "test.y:1:8: expected nonterminal after %start, got \"calc\""},
+		{"%start Calc Calc\n", "test.y:1:13: start symbol Calc named twice"},
+		{"%start Calc\n%%\nAdd : id\n    ;\n", "test.y:1:8: start symbol Calc has no rules"},
+		{"%%\nAdd : Add '+' Add { $$ = $Add }\n    ;\n", "test.y:2:26: ambiguous reference $Add in rule Add"},
+		{"%%\nAdd : id { $$ = $left }\n    ;\n", "test.y:2:17: unknown reference $left in rule Add"},
+		{"%%\nAdd : id[v] { $$ = $id }\n    ;\n", "test.y:2:20: unknown reference $id in rule Add"},
+		{"%%\nAdd : id { $$ = $1 +\n $2 }\n    ;\n", "test.y:3:2: $2 is out of range in rule Add, which has 1 symbols"},
+		{"%%\nAdd : id { @$ = @2 }\n    ;\n", "test.y:2:17: @2 is out of range in rule Add, which has 1 symbols"},
+		{"%%\nAdd : id { @$ = @left }\n    ;\n", "test.y:2:17: unknown reference @left in rule Add"},
+		{"%%\nAdd : id { $$ = $2 } id\n    ;\n", "test.y:2:17: $2 is out of range in rule Add, which has 1 symbols"},
+		{"%defaultcode { $$ = $2 }\n%%\nAdd : id id\n    | id\n    ;\n", "test.y:1:21: $2 of %defaultcode is out of range in Add : id"},
+	}
+	for _, c := range cases {
+		grammar := NewGrammar()
+
+		scanner := &Scanner{name: "test.y", content: []byte(c.content), index: 0}
+		err := grammar.ParseHeaders(scanner)
+		if err == nil {
+			err = grammar.ParseGrammars(scanner)
+		}
+		if err == nil {
+			t.Errorf("Expected error %s for:\n%s", c.err, c.content)
+			continue
+		}
+		if _, b := err.(*GrammarError); !b {
+			t.Errorf("Expected a *GrammarError, got %T", err)
+		}
+		if err.Error() != c.err {
+			t.Errorf("Expected error:\n\t%s\nGot:\n\t%s", c.err, err.Error())
+		}
+	}
+}
+
+func TestParseOneLineRules(t *testing.T) {
+	t.Parallel()
+	content := `E : T '+' E | T ;
+T : id ;
+%%`
+	grammar := NewGrammar()
+
+	scanner := &Scanner{content: []byte(content), index: 0}
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	expectedProds := []string{"E: [T, '+', E]", "E: [T]", "T: [id]"}
+	if len(grammar.prods) != len(expectedProds) {
+		t.Fatalf("Expected %d productions, got %d", len(expectedProds), len(grammar.prods))
+	}
+	for i, prod := range grammar.prods {
+		if gotProd := prod2string(&prod); gotProd != expectedProds[i] {
+			t.Errorf("Expected: %s, Got: %s", expectedProds[i], gotProd)
+		}
+	}
+
+	// alternatives start at their ':' or '|'
+	expectedPos := []string{"1:3", "1:13", "2:3"}
+	for i, prod := range grammar.prods {
+		if prod.pos.String() != expectedPos[i] {
+			t.Errorf("Expected %s at %s, got %s", prod, expectedPos[i], prod.pos)
+		}
+	}
+	if grammar.prods[0].bodyPos[1].String() != "1:7" {
+		t.Errorf("Expected '+' at 1:7, got %s", grammar.prods[0].bodyPos[1])
+	}
+}
+
+func TestParseNamedReferences(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%union {
+    n int
+}
+%token<n> num
+%type<n> Add List
+%%
+Add : Add[left] '+'[op] num { $$ = $left + $num; _ = $op; @$ = @[op]; _ = @left.End }
+    | num                   { $Add = $<n>[num] }
+    ;
+List : Add Add Add Add Add Add Add Add Add Add Add { $List = $1 + $10 + $11 }
+     | (Add | num)*[items] { $$ = len($items) }
+     ;`)
+	expected := []string{
+		"{ $$ = $1 + $3; _ = $2; @$ = @2; _ = @1.End }",
+		"{ $$ = $<n>1 }",
+		"{ $$ = $1 + $10 + $11 }",
+		"{ $$ = len($1) }",
+	}
+	codes := make([]string, 0)
+	for _, prod := range grammar.prods {
+		if len(prod.code) > 0 && prod.code != "{ }" && !strings.Contains(prod.name, "_") {
+			codes = append(codes, prod.code)
+		}
+	}
+	if strings.Join(codes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(codes, "\n"))
+	}
+	if body := grammar.prods[0].body; body[0] != "Add" || body[1] != "'+'" {
+		t.Errorf("Expected the names to be left out of the body, got %v", body)
+	}
+}
+
+func TestCheckActions(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"Num : integer { $$ = $1 + }\n    ;\n":                   "test.y:4:27: invalid action: expected operand, found '}'",
+		"Num : integer { $$ = \"$2\" }\n    ;\n":                 "",
+		"Num : integer { $$ = T{$1}; if $1 > 0 {\n } }\n    ;\n": "",
+		"Num : integer { $$ = $1\n    $$ := }\n    ;\n":          "test.y:5:11: invalid action: expected operand, found '}'",
+		"Num : integer { @$ = @1; _ = @$.Start.Line }\n    ;\n":  "",
+	}
+	for content, expected := range cases {
+		grammar := NewGrammar()
+		scanner := &Scanner{name: "test.y", content: []byte("%token<n> integer\n%type<n> Num\n%%\n" + content), index: 0}
+		err := grammar.ParseHeaders(scanner)
+		if err == nil {
+			err = grammar.ParseGrammars(scanner)
+		}
+		if err == nil {
+			err = grammar.checkActions()
+		}
+		if (err == nil && len(expected) > 0) || (err != nil && err.Error() != expected) {
+			t.Errorf("Expected error %q for %q, got %v", expected, content, err)
+		}
+	}
+
+	// values in strings and comments are not references
+	grammar := parseTestGrammar(t, "%%\nNum : integer { fmt.Println(\"$1\", '$', $1, \"@1\") /* $$ @$ */ }\n    ;")
+	refs := findActionRefs(grammar.prods[0].code)
+	if len(refs) != 1 || refs[0].index != 1 {
+		t.Errorf("Expected only $1 outside the literals, got %v", refs)
+	}
+}
+
+func TestCheckUntypedRefs(t *testing.T) {
+	t.Parallel()
+	header := "%union {\n    n int\n}\n%token<n> num\n"
+	cases := map[string]string{
+		"%%\nA : num B\n  ;\nB : x { _ = $0 }\n  ;\n":        "test.y:8:13: $0 needs a <field> in rule B",
+		"%%\nA : num B\n  ;\nB : x { _ = $-1 }\n  ;\n":       "test.y:8:13: $-1 needs a <field> in rule B",
+		"%%\nA : num B\n  ;\nB : x { _ = $<n>0 }\n  ;\n":     "",
+		"%%\nA : num x { _ = $1 + $2 }\n  ;\n":               "test.y:6:22: $2 refers to x, which has no type in rule A",
+		"%%\nA : num x[v] { _ = $v }\n  ;\n":                 "test.y:6:20: $2 refers to x, which has no type in rule A",
+		"%%\nS : num { $$ = 1 }\n  ;\n":                      "test.y:6:11: $$ refers to S, which has no type in rule S",
+		"%%\nS : num { $<n>$ = 1 }\n  ;\n":                   "",
+		"%%\nA : num x { _ = $<n>2 }\n  ;\n":                 "",
+		"%defaultcode { _ = $1 }\n%%\nA : num\n  | x\n  ;\n": "test.y:5:20: $1 refers to x, which has no type in rule A",
+	}
+	for content, expected := range cases {
+		grammar := NewGrammar()
+		scanner := &Scanner{name: "test.y", content: []byte(header + content), index: 0}
+		err := grammar.ParseHeaders(scanner)
+		if err == nil {
+			err = grammar.ParseGrammars(scanner)
+		}
+		if err == nil {
+			err = grammar.checkActions()
+		}
+		if (err == nil && len(expected) > 0) || (err != nil && err.Error() != expected) {
+			t.Errorf("Expected error %q for %q, got %v", expected, content, err)
+		}
+	}
+}
+
+func TestParseMidRuleActions(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%union {
+    n int
+}
+%token<n> id
+%%
+Block : '{' { $$ = <$1 } Stmts '}' { $$ = $2 $3 $4> }
+      ;
+Stmts : Stmts id { $$ = $1 $2 }
+      | id { $<n>$ = $<n>0 } { $$ = $1 }
+      ;`)
+	var buf bytes.Buffer
+	if err := grammar.DumpGrammar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `%type<n> Stmts_act
+
+%%
+
+Block : '{' Block_act Stmts '}'    { $$ = $2 $3 $4> }
+      ;
+
+Block_act :    { $$ = <$0 }
+          ;
+
+Stmts : Stmts id    { $$ = $1 $2 }
+      | id Stmts_act    { $$ = $1 }
+      ;
+
+Stmts_act :    { $<n>$ = $<n>-1 }
+          ;
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	symbols := grammar.MergeSymbols()
+	table, _ := ComputeLRTable(LALR, grammar.prods, symbols, nil, grammar.maxToken)
+	if value := evalLR(t, grammar, table, "'{' id id '}'"); value != "<'{' id id '}'>" {
+		t.Errorf("Expected the mid-rule value to reach the rule, got %s", value)
+	}
+}
+
 func checkMap(expected map[string]string, checked map[string]string, t *testing.T) {
 	for vname, vtype := range expected {
 		v, b := checked[vname]
@@ -1,11 +1,10 @@
 %package main     # Set the package of the generated file to "main"
 
-%import fmt os strconv  # import (
-                                #   scanner
-                                #   fmt
-                                #   os
-                                #   strconv
-                                # )
+%import bufio fmt os    # import (
+                        #   bufio
+                        #   fmt
+                        #   os
+                        # )
 
 # Replace the default { $$ = $1 } rule code with this custom code.
 %defaultcode {
@@ -19,11 +18,16 @@
     op func(float64)float64
 }
 
-# Associate the "floating" terminal with the type of fval float
-%token<fval> floating
+# Associate the "floating" terminal with the type of fval float, and match
+# it with a regular expression in the generated yylexer
+%token<fval> floating /[0-9]+\.[0-9]*/
 
 # Associate the "integer" terminal with the type of ival int
-%token<ival> integer
+%token<ival> integer /[0-9]+/
+
+# The text yylexer leaves out between words; the literals of the rules are
+# matched as written
+%skip /[ \t]+/
 
 # Associate the "Calc", "Num", "Mult", and "Add" nonterminals with the type of fval float 
 %type<fval> Calc Num Mult Add
@@ -58,11 +62,6 @@ Num : floating          { fmt.Println("9. Found Num->floating. Forwarding value"
 
 %%
 
-// Define the end-of-file token used by the scanner
-/* const ( */
-/*     EOF = yyUSER + 1 */
-/* ) */
-
 // Define functions used by the grammar above
 func mult(m float64) func(float64)float64 {
     return func(f float64)float64 {
@@ -92,51 +91,18 @@ func noop(m float64) float64 {
     return m
 }
 
-// Entry point for executable
+// Entry point for executable: every line of the standard input is parsed
+// and its value printed
 func main() {
-    reader := os.Stdin
-    for true {
-        var s scanner.Scanner
-        s.Init(reader)
-        
-        // Define a scanner function for the yyparse function
-        nextWord := func(v *yystype)int {
-            i := s.Scan()
-            switch i {
-            case scanner.Float:
-                // Set the value of the string conversion to the float64 slot
-                v.fval, _ = strconv.Atof(s.TokenText())
-                return float64ing
-            case scanner.Int:
-                // Set the value of the string conversion to the int slot
-                v.ival, _ = strconv.Atoi(s.TokenText())
-                return integer
-            case scanner.Ident:
-                // Return EOF for the token "eof"
-                if s.TokenText() == "eof" {return EOF}
-                return -1
-            case scanner.String:
-                return -1
-            case scanner.Char:
-                return -1
-            case scanner.RawString:
-                return -1
-            case scanner.Comment:
-                return -1
-            case scanner.EOF:
-                return EOF
-            default:
-                return i
-            }
-            return -1
-        }
-        
-        // Print the result if the parser recognized the input
+    lines := bufio.NewScanner(os.Stdin)
+    for lines.Scan() {
+        // Print the result if the parser recognized the line, which the
+        // generated yylexer splits into words
         // Otherwise, print a colloquial but unhelpful message
-        if ok, result := yyparse(EOF, nextWord); ok {
-            fmt.Println("Result:", result)
+        if result, err := yyparserSpans(yylexer(lines.Text())); err == nil {
+            fmt.Println("Result:", result.fval)
         } else {
-            fmt.Println("Can't parse that, dude.")
+            fmt.Println("Can't parse that, dude:", err)
         }
     }
 }
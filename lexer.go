@@ -0,0 +1,495 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// lexPattern is a /regexp/ of a %token line, or of %skip if name is "".
+type lexPattern struct {
+	name string
+	expr string // without the slashes
+	pos  Position
+}
+
+// lexDFA is the automaton of the generated lexer. Runes no pattern tells
+// apart share a class: class i holds the runes from classes[i] up to the
+// start of the next one. trans[state][class] is the next state+1, 0 if
+// there is none; accept[state] is the word the text read up to state
+// matches, -1 if none. State 0 is the start.
+type lexDFA struct {
+	classes []rune
+	trans   [][]int
+	accept  []int
+	// the symbol of every word, "" for text that is skipped
+	words []string
+}
+
+// addPattern records the pattern word for the token name, "" for %skip.
+func (self *Grammar) addPattern(name string, word WordTok) error {
+	for _, p := range self.patterns {
+		if len(name) > 0 && p.name == name {
+			return &GrammarError{Position: word.pos, Msg: fmt.Sprintf("token %s has a pattern already", name)}
+		}
+	}
+	expr := word.text[1 : len(word.text)-1]
+	re, err := parsePattern(expr)
+	if err == nil {
+		nfa := &lexNFA{}
+		_, err = nfa.compile(re, nfa.state())
+	}
+	if err != nil {
+		return &GrammarError{Position: word.pos, Msg: fmt.Sprintf("invalid pattern %s: %s", word.text, err)}
+	}
+	self.patterns = append(self.patterns, lexPattern{name: name, expr: expr, pos: word.pos})
+	return nil
+}
+
+// parsePattern parses a pattern with the syntax of package regexp.
+func parsePattern(expr string) (*syntax.Regexp, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		if serr, b := err.(*syntax.Error); b {
+			return nil, fmt.Errorf("%s: `%s`", serr.Code, serr.Expr)
+		}
+		return nil, err
+	}
+	return re.Simplify(), nil
+}
+
+// lexNFA is a Thompson automaton whose edges take a range of runes.
+type lexNFA struct {
+	eps    [][]int
+	edges  [][]lexEdge
+	accept []int // the word a state ends, -1 if none
+}
+
+type lexEdge struct {
+	lo, hi rune
+	to     int
+}
+
+func (self *lexNFA) state() int {
+	self.eps = append(self.eps, nil)
+	self.edges = append(self.edges, nil)
+	self.accept = append(self.accept, -1)
+	return len(self.accept) - 1
+}
+
+func (self *lexNFA) edge(from int, lo, hi rune, to int) {
+	self.edges[from] = append(self.edges[from], lexEdge{lo: lo, hi: hi, to: to})
+}
+
+// compile adds the states matching re after state from and returns the one
+// the match ends in. Loops and branches start at states of their own, so
+// from can be shared with what comes before.
+func (self *lexNFA) compile(re *syntax.Regexp, from int) (int, error) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return self.state(), nil
+	case syntax.OpEmptyMatch:
+		return from, nil
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			to := self.state()
+			if re.Flags&syntax.FoldCase != 0 {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					self.edge(from, f, f, to)
+				}
+			}
+			self.edge(from, r, r, to)
+			from = to
+		}
+		return from, nil
+	case syntax.OpCharClass:
+		to := self.state()
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			self.edge(from, re.Rune[i], re.Rune[i+1], to)
+		}
+		return to, nil
+	case syntax.OpAnyCharNotNL:
+		to := self.state()
+		self.edge(from, 0, '\n'-1, to)
+		self.edge(from, '\n'+1, unicode.MaxRune, to)
+		return to, nil
+	case syntax.OpAnyChar:
+		to := self.state()
+		self.edge(from, 0, unicode.MaxRune, to)
+		return to, nil
+	case syntax.OpCapture:
+		return self.compile(re.Sub[0], from)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			var err error
+			if from, err = self.compile(sub, from); err != nil {
+				return 0, err
+			}
+		}
+		return from, nil
+	case syntax.OpAlternate:
+		to := self.state()
+		for _, sub := range re.Sub {
+			start := self.state()
+			self.eps[from] = append(self.eps[from], start)
+			end, err := self.compile(sub, start)
+			if err != nil {
+				return 0, err
+			}
+			self.eps[end] = append(self.eps[end], to)
+		}
+		return to, nil
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		start := self.state()
+		self.eps[from] = append(self.eps[from], start)
+		end, err := self.compile(re.Sub[0], start)
+		if err != nil {
+			return 0, err
+		}
+		to := self.state()
+		self.eps[end] = append(self.eps[end], to)
+		if re.Op != syntax.OpPlus {
+			self.eps[start] = append(self.eps[start], to)
+		}
+		if re.Op != syntax.OpQuest {
+			self.eps[to] = append(self.eps[to], start)
+		}
+		return to, nil
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return 0, fmt.Errorf("anchors are not supported")
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return 0, fmt.Errorf("word boundaries are not supported")
+	}
+	return 0, fmt.Errorf("%s is not supported", re)
+}
+
+// closure adds the states the states of set reach without reading and
+// returns them sorted.
+func (self *lexNFA) closure(set []int) []int {
+	seen := make(map[int]bool)
+	for i := 0; i < len(set); i++ {
+		if seen[set[i]] {
+			continue
+		}
+		seen[set[i]] = true
+		set = append(set, self.eps[set[i]]...)
+	}
+	closed := make([]int, 0, len(seen))
+	for s := range seen {
+		closed = append(closed, s)
+	}
+	sort.Ints(closed)
+	return closed
+}
+
+// classes returns the first rune of every class of runes the edges do not
+// tell apart.
+func (self *lexNFA) classes() []rune {
+	starts := map[rune]bool{0: true}
+	for _, edges := range self.edges {
+		for _, e := range edges {
+			starts[e.lo] = true
+			if e.hi < unicode.MaxRune {
+				starts[e.hi+1] = true
+			}
+		}
+	}
+	classes := make([]rune, 0, len(starts))
+	for r := range starts {
+		classes = append(classes, r)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+	return classes
+}
+
+// unquoteLiteral returns the text a literal such as '+' or '\n' stands for,
+// its escapes being those of Go.
+func unquoteLiteral(lit string) (string, error) {
+	if len(lit) < 2 || lit[0] != '\'' || lit[len(lit)-1] != '\'' {
+		return "", strconv.ErrSyntax
+	}
+	var text strings.Builder
+	for rest := lit[1 : len(lit)-1]; len(rest) > 0; {
+		r, multibyte, tail, err := strconv.UnquoteChar(rest, '\'')
+		if err != nil {
+			return "", err
+		}
+		if multibyte || r < utf8.RuneSelf {
+			text.WriteRune(r)
+		} else {
+			text.WriteByte(byte(r))
+		}
+		rest = tail
+	}
+	return text.String(), nil
+}
+
+// buildLexer builds the automaton of the generated lexer from the literals
+// of the rules, the %token patterns and the %skip patterns. The longest
+// match wins; on ties literals win over %token patterns, which win over
+// %skip ones, and patterns of a kind go by the order they are declared in.
+// A grammar without patterns gets no lexer.
+func (self *Grammar) buildLexer() error {
+	if len(self.patterns) == 0 {
+		return nil
+	}
+	nfa := &lexNFA{}
+	start := nfa.state()
+	// the symbol, pattern and position of every word
+	words := make([]string, 0)
+	exprs := make([]string, 0)
+	positions := make([]Position, 0)
+
+	literals := make([]string, 0, len(self.literalSet))
+	for lit := range self.literalSet {
+		literals = append(literals, lit)
+	}
+	sort.Slice(literals, func(i, j int) bool { return self.literalSet[literals[i]] < self.literalSet[literals[j]] })
+	for _, lit := range literals {
+		if lit == "''" {
+			continue
+		}
+		text, _ := unquoteLiteral(lit)
+		end := nfa.state()
+		nfa.eps[start] = append(nfa.eps[start], end)
+		for _, r := range text {
+			to := nfa.state()
+			nfa.edge(end, r, r, to)
+			end = to
+		}
+		nfa.accept[end] = len(words)
+		words = append(words, lit)
+		exprs = append(exprs, lit)
+		positions = append(positions, Position{})
+	}
+	patterns := make([]lexPattern, 0, len(self.patterns))
+	for _, skip := range []bool{false, true} {
+		for _, p := range self.patterns {
+			if (len(p.name) == 0) == skip {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	for _, p := range patterns {
+		re, err := parsePattern(p.expr)
+		if err == nil {
+			begin := nfa.state()
+			nfa.eps[start] = append(nfa.eps[start], begin)
+			var end int
+			if end, err = nfa.compile(re, begin); err == nil {
+				nfa.accept[end] = len(words)
+			}
+		}
+		if err != nil {
+			return &GrammarError{Position: p.pos, Msg: fmt.Sprintf("invalid pattern /%s/: %s", p.expr, err)}
+		}
+		words = append(words, p.name)
+		exprs = append(exprs, p.expr)
+		positions = append(positions, p.pos)
+	}
+
+	dfa := &lexDFA{classes: nfa.classes(), words: words}
+	ids := make(map[string]int)
+	sets := make([][]int, 0)
+	add := func(set []int) int {
+		key := fmt.Sprint(set)
+		if id, b := ids[key]; b {
+			return id
+		}
+		accept := -1
+		for _, s := range set {
+			if nfa.accept[s] >= 0 && (accept < 0 || nfa.accept[s] < accept) {
+				accept = nfa.accept[s]
+			}
+		}
+		ids[key] = len(sets)
+		sets = append(sets, set)
+		dfa.trans = append(dfa.trans, make([]int, len(dfa.classes)))
+		dfa.accept = append(dfa.accept, accept)
+		return len(sets) - 1
+	}
+	add(nfa.closure([]int{start}))
+	if word := dfa.accept[0]; word >= 0 {
+		return &GrammarError{Position: positions[word], Msg: fmt.Sprintf("pattern /%s/ matches the empty string", exprs[word])}
+	}
+	class := func(r rune) int {
+		return sort.Search(len(dfa.classes), func(i int) bool { return dfa.classes[i] > r }) - 1
+	}
+	for i := 0; i < len(sets); i++ {
+		next := make([][]int, len(dfa.classes))
+		for _, s := range sets[i] {
+			for _, e := range nfa.edges[s] {
+				for c := class(e.lo); c <= class(e.hi); c++ {
+					next[c] = append(next[c], e.to)
+				}
+			}
+		}
+		for c, set := range next {
+			if len(set) > 0 {
+				to := add(nfa.closure(set))
+				dfa.trans[i][c] = to + 1
+			}
+		}
+	}
+	self.lexer = dfa
+
+	self.addModule("unicode/utf8")
+	for _, p := range patterns {
+		if parse, _ := lexValue(self.unionTypes[self.termTypes[p.name]]); len(parse) > 0 {
+			self.addModule("strconv")
+		}
+	}
+	return nil
+}
+
+// addModule imports module into the generated file, unless it is already.
+func (self *Grammar) addModule(module string) {
+	for _, m := range self.modules {
+		if m == module {
+			return
+		}
+	}
+	self.modules = append(self.modules, module)
+}
+
+// lexValue returns how text is converted to a value of typeName: the
+// statement parsing it into v, if any, and the value. The value is "" for
+// types the lexer cannot convert.
+func lexValue(typeName string) (parse, value string) {
+	switch typeName = strings.TrimSpace(typeName); typeName {
+	case "string":
+		return "", "text"
+	case "[]byte":
+		return "", "[]byte(text)"
+	case "int":
+		return "v, _ := strconv.Atoi(text)", "v"
+	case "int8", "int16", "int32", "int64":
+		return fmt.Sprintf("v, _ := strconv.ParseInt(text, 10, %s)", typeName[3:]), typeName + "(v)"
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		bits := typeName[4:]
+		if len(bits) == 0 {
+			bits = "0"
+		}
+		return fmt.Sprintf("v, _ := strconv.ParseUint(text, 10, %s)", bits), typeName + "(v)"
+	case "float32", "float64":
+		return fmt.Sprintf("v, _ := strconv.ParseFloat(text, %s)", typeName[5:]), typeName + "(v)"
+	case "bool":
+		return "v, _ := strconv.ParseBool(text)", "v"
+	}
+	return "", ""
+}
+
+// printLexer writes yylexer, which splits a string into the words yyparser
+// takes, with the tables of the lexer and yylexvalue, which gives the value
+// of a word.
+func (self *Grammar) printLexer(out *bytes.Buffer) {
+	dfa := self.lexer
+	out.WriteString("// the first rune of every class of runes the lexer does not tell apart\n")
+	out.WriteString("var yylexclasses = []rune{")
+	for i, r := range dfa.classes {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(fmt.Sprint(r))
+	}
+	out.WriteString("}\n\n")
+	out.WriteString("// yylextrans[state][class] is the next state+1, 0 if there is none\n")
+	out.WriteString("var yylextrans = [][]int{\n")
+	for _, row := range dfa.trans {
+		out.WriteString(fmt.Sprintf("\t%s,\n", intSlice(row)))
+	}
+	out.WriteString("}\n\n")
+	out.WriteString("// yylexaccept[state] is the word of yylexwords the text read up to state\n// matches, -1 if none\n")
+	out.WriteString(fmt.Sprintf("var yylexaccept = %s\n\n", intSlice(dfa.accept)))
+	out.WriteString("// the symbol of every word, \"\" for text that is skipped\n")
+	out.WriteString("var yylexwords = []string{")
+	for i, word := range dfa.words {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(fmt.Sprintf("%q", word))
+	}
+	out.WriteString("}\n\n")
+
+	out.WriteString(`// yylexvalue gives the value of a word matched as text. Tokens of a string,
+// bool or number type get the text converted, others an empty value; set
+// it to convert them.
+var yylexvalue = func(word, text string) *yytype {
+    switch word {
+`)
+	for _, p := range self.patterns {
+		field := self.termTypes[p.name]
+		parse, value := lexValue(self.unionTypes[field])
+		if len(p.name) == 0 || len(value) == 0 {
+			continue
+		}
+		out.WriteString(fmt.Sprintf("\tcase %q:\n", p.name))
+		if len(parse) > 0 {
+			out.WriteString(fmt.Sprintf("\t\t%s\n", parse))
+		}
+		out.WriteString(fmt.Sprintf("\t\treturn &yytype{%s: %s}\n", field, value))
+	}
+	out.WriteString("\t}\n\treturn &yytype{}\n}\n\n")
+
+	out.WriteString(`// yylexer returns the words of input for yyparserSpans. A word is the
+// longest text a literal or %token pattern matches, a literal on ties with a
+// pattern and else the pattern declared first; text a %skip pattern matches
+// is left out, and a rune no pattern matches is a word of its own.
+func yylexer(input string) func() (bool, string, *yytype, Span) {
+    at := Position{Offset: 0, Line: 1, Column: 1}
+    // next moves at past the n bytes of input after it
+    next := func(n int) Span {
+        start := at
+        for _, c := range []byte(input[at.Offset : at.Offset+n]) {
+            at.Offset++
+            at.Column++
+            if c == '\n' {
+                at.Line++
+                at.Column = 1
+            }
+        }
+        return Span{start, at}
+    }
+    return func() (bool, string, *yytype, Span) {
+        for at.Offset < len(input) {
+            rest := input[at.Offset:]
+            state, word, end := 0, -1, 0
+            for i := 0; i < len(rest); {
+                r, size := utf8.DecodeRuneInString(rest[i:])
+                // the class of r is the last one starting at or below it
+                lo, hi := 0, len(yylexclasses)
+                for hi-lo > 1 {
+                    if mid := (lo + hi) / 2; yylexclasses[mid] <= r {
+                        lo = mid
+                    } else {
+                        hi = mid
+                    }
+                }
+                if state = yylextrans[state][lo] - 1; state < 0 {
+                    break
+                }
+                i += size
+                if yylexaccept[state] >= 0 {
+                    word, end = yylexaccept[state], i
+                }
+            }
+            if word < 0 {
+                _, end = utf8.DecodeRuneInString(rest)
+                return false, rest[:end], &yytype{}, next(end)
+            }
+            span := next(end)
+            if name := yylexwords[word]; len(name) > 0 {
+                return false, name, yylexvalue(name, rest[:end]), span
+            }
+        }
+        return true, "", nil, Span{at, at}
+    }
+}
+
+`)
+}
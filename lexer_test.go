@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// lexWords splits input with the lexer of grammar as the generated yylexer
+// does, a word and its text being written as word=text.
+func lexWords(grammar *Grammar, input string) string {
+	dfa := grammar.lexer
+	words := make([]string, 0)
+	for len(input) > 0 {
+		state, word, end := 0, -1, 0
+		for at := 0; at < len(input); {
+			r, size := utf8.DecodeRuneInString(input[at:])
+			class := 0
+			for class+1 < len(dfa.classes) && dfa.classes[class+1] <= r {
+				class++
+			}
+			if state = dfa.trans[state][class] - 1; state < 0 {
+				break
+			}
+			at += size
+			if dfa.accept[state] >= 0 {
+				word, end = dfa.accept[state], at
+			}
+		}
+		if word < 0 {
+			_, end = utf8.DecodeRuneInString(input)
+			words = append(words, "?="+input[:end])
+		} else if len(dfa.words[word]) > 0 {
+			words = append(words, dfa.words[word]+"="+input[:end])
+		}
+		input = input[end:]
+	}
+	return strings.Join(words, " ")
+}
+
+func TestLexer(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%union {
+    n int
+    s string
+}
+%token<n> num /[0-9]+|0x[0-9a-f]+/
+%token kw /(?i)begin/
+%token<s> id /[a-zA-Z_][a-zA-Z_0-9]*/ str /"([^"\\]|\\.)*"/
+%skip /[ \t\n]+/ /#[^\n]*/ /\/\*([^*]|\*+[^*\/])*\*+\//
+%%
+S : 'if' id '==' num
+  | '=' str
+  | kw 'é'
+  ;
+`)
+	if err := grammar.buildLexer(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"if x == 10":        "'if'=if id=x '=='=== num=10",
+		"iffy = 0x1f":       "id=iffy '='== num=0x1f",
+		"a==b # comment\nc": "id=a '=='=== id=b id=c",
+		"a /* * */ b /**/":  "id=a id=b",
+		`"a\"b" = "c"`:      `str="a\"b" '='== str="c"`,
+		"BEGIN Begin é":     "kw=BEGIN kw=Begin 'é'=é",
+		"a+b\t€":            "id=a ?=+ id=b ?=€",
+		"/* never closed":   "?=/ ?=* id=never id=closed",
+		"10abc":             "num=10 id=abc",
+		"":                  "",
+	}
+	for input, expected := range cases {
+		if got := lexWords(grammar, input); got != expected {
+			t.Errorf("Expected %q to be %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestLexerEscapedLiterals(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%token id /[a-z]+/
+%skip / +/
+%%
+S : '\'' id '\\' '\t' '\x41'
+  ;
+`)
+	if err := grammar.buildLexer(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"'a\\\tA": `'\''=' id=a '\\'=\ '\t'=` + "\t" + ` '\x41'=A`,
+		"'\\' b":  `'\''=' '\\'=\ '\''=' id=b`,
+		"\\t":     `'\\'=\ id=t`,
+	}
+	for input, expected := range cases {
+		if got := lexWords(grammar, input); got != expected {
+			t.Errorf("Expected %q to be %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"%token num /[0-9]*/\n%%\nS : num\n  ;\n": "1:12: pattern /[0-9]*/ matches the empty string",
+		"%skip /[ ]+/ /a|b?/\n%%\nS : 'x'\n  ;\n": "1:14: pattern /a|b?/ matches the empty string",
+		"%token num /[0-9]+/\n%%\nS : num\n  ;\n": "",
+		"%%\nS : 'x'\n  ;\n":                      "",
+	}
+	for content, expected := range cases {
+		grammar := parseTestGrammar(t, content)
+		err := grammar.buildLexer()
+		if len(expected) == 0 && err != nil {
+			t.Errorf("Unexpected error %s for:\n%s", err, content)
+		} else if len(expected) > 0 && (err == nil || err.Error() != expected) {
+			t.Errorf("Expected error %s for:\n%s\nGot: %v", expected, content, err)
+		}
+	}
+	if grammar := parseTestGrammar(t, "%%\nS : 'x'\n  ;\n"); grammar.buildLexer() != nil || grammar.lexer != nil {
+		t.Errorf("Expected no lexer for a grammar without patterns")
+	}
+}
@@ -0,0 +1,286 @@
+package parser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxLookahead bounds the search for the lookahead a grammar needs.
+const maxLookahead = 4
+
+// Lookahead is a sequence of at most k symbol ids. It is shorter than k
+// only when the input ends, its last id then is the one of "$".
+type Lookahead []int
+
+func (self Lookahead) key() string {
+	parts := make([]string, len(self))
+	for i, id := range self {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, " ")
+}
+
+// complete tells whether nothing can follow the sequence in a k window.
+func (self Lookahead) complete(k int) bool {
+	return len(self) >= k || (len(self) > 0 && self[len(self)-1] == 1)
+}
+
+// LookaheadSet holds lookahead sequences by their key.
+type LookaheadSet map[string]Lookahead
+
+func (self LookaheadSet) add(seq Lookahead) bool {
+	key := seq.key()
+	if _, b := self[key]; b {
+		return false
+	}
+	self[key] = seq
+	return true
+}
+
+func (self LookaheadSet) merge(other LookaheadSet) bool {
+	changed := false
+	for _, seq := range other {
+		changed = self.add(seq) || changed
+	}
+	return changed
+}
+
+// sorted returns the sequences in a stable order.
+func (self LookaheadSet) sorted() []Lookahead {
+	seqs := make([]Lookahead, 0, len(self))
+	for _, seq := range self {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		a, b := seqs[i], seqs[j]
+		for n := 0; n < len(a) && n < len(b); n++ {
+			if a[n] != b[n] {
+				return a[n] < b[n]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return seqs
+}
+
+// concatK is the k-prefix of every sequence of lhs followed by one of rhs.
+func concatK(lhs, rhs LookaheadSet, k int) LookaheadSet {
+	result := make(LookaheadSet)
+	for _, x := range lhs {
+		if x.complete(k) {
+			result.add(x)
+			continue
+		}
+		for _, y := range rhs {
+			seq := append(append(Lookahead{}, x...), y...)
+			if len(seq) > k {
+				seq = seq[:k]
+			}
+			result.add(seq)
+		}
+	}
+	return result
+}
+
+// firstOfBody is FIRST_k of a sequence of symbols, the empty sequence
+// standing for ε.
+func firstOfBody(body []string, firsts map[string]LookaheadSet, k int) LookaheadSet {
+	result := LookaheadSet{"": Lookahead{}}
+	for _, sym := range body {
+		result = concatK(result, firsts[sym], k)
+	}
+	return result
+}
+
+// ComputeFirstsK computes FIRST_k of every symbol: the token sequences of
+// length k its derivations start with, or shorter ones they consist of.
+func ComputeFirstsK(prods []Production,
+	tokens map[string]int,
+	maxterm, k int) map[string]LookaheadSet {
+	firsts := make(map[string]LookaheadSet)
+	for tok, id := range tokens {
+		firsts[tok] = make(LookaheadSet)
+		if id <= maxterm && id > 0 {
+			firsts[tok].add(Lookahead{id})
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, prod := range prods {
+			if firsts[prod.name].merge(firstOfBody(prod.body, firsts, k)) {
+				changed = true
+			}
+		}
+	}
+	return firsts
+}
+
+// ComputeFollowsK computes FOLLOW_k of every nonterminal, sequences ending
+// the input close with "$". The input ends after the start symbols, the
+// first production's name unless given.
+func ComputeFollowsK(prods []Production,
+	tokens map[string]int,
+	firsts map[string]LookaheadSet,
+	maxterm, k int,
+	starts ...string) map[string]LookaheadSet {
+	follows := make(map[string]LookaheadSet)
+	for tok, id := range tokens {
+		if id > maxterm {
+			follows[tok] = make(LookaheadSet)
+		}
+	}
+	for _, start := range startSymbols(prods, starts) {
+		follows[start].add(Lookahead{1})
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, prod := range prods {
+			for i, sym := range prod.body {
+				if tokens[sym] <= maxterm {
+					continue
+				}
+				rest := concatK(firstOfBody(prod.body[i+1:], firsts, k), follows[prod.name], k)
+				if follows[sym].merge(rest) {
+					changed = true
+				}
+			}
+		}
+	}
+	return follows
+}
+
+// Prediction tells to expand Prod when the input starts with Lookahead.
+type Prediction struct {
+	Lookahead Lookahead
+	Prod      int
+}
+
+// ComputeLLkTable builds the strong LL(k) decisions of every nonterminal.
+// As in ComputeLLTable, a sequence claimed by two productions goes to the
+// first one and the clash is returned as a Conflict.
+func ComputeLLkTable(prods []Production,
+	tokens map[string]int,
+	firsts map[string]LookaheadSet,
+	follows map[string]LookaheadSet,
+	maxterm, k int) (map[int][]Prediction, []Conflict) {
+	names := make(map[int]string)
+	for name, id := range tokens {
+		names[id] = name
+	}
+
+	lookaheads := make(map[int]LookaheadSet)
+	decisions := make(map[int]map[string]int)
+	fromFollow := make(map[int]map[string]bool)
+	conflicts := make([]Conflict, 0)
+	for i, prod := range prods {
+		row := tokens[prod.name]
+		if _, b := decisions[row]; !b {
+			lookaheads[row] = make(LookaheadSet)
+			decisions[row] = make(map[string]int)
+			fromFollow[row] = make(map[string]bool)
+		}
+		first := firstOfBody(prod.body, firsts, k)
+		for _, seq := range concatK(first, follows[prod.name], k).sorted() {
+			key := seq.key()
+			// sequences missing from first needed a look past the body
+			_, inFirst := first[key]
+			follow := !inFirst
+			old, b := decisions[row][key]
+			if !b {
+				lookaheads[row].add(seq)
+				decisions[row][key] = i
+				fromFollow[row][key] = follow
+				continue
+			}
+			// as in ComputeLLTable, a sequence either production reaches
+			// through FOLLOW is a FIRST/FOLLOW clash
+			kind := FirstFirst
+			if follow || fromFollow[row][key] {
+				kind = FirstFollow
+			}
+			words := make([]string, len(seq))
+			for n, id := range seq {
+				words[n] = names[id]
+			}
+			conflicts = append(conflicts, Conflict{
+				Kind:      kind,
+				Nonterm:   prod.name,
+				Lookahead: strings.Join(words, " "),
+				Prods:     [2]int{old, i},
+				Bodies:    [2]Production{prods[old], prod},
+			})
+		}
+	}
+
+	table := make(map[int][]Prediction)
+	for row, set := range lookaheads {
+		for _, seq := range set.sorted() {
+			table[row] = append(table[row], Prediction{Lookahead: seq, Prod: decisions[row][seq.key()]})
+		}
+	}
+	return table, conflicts
+}
+
+// MinimalLookahead returns the smallest k up to maxK for which the grammar
+// is strong LL(k) from the start symbols, or 0 if there is none.
+func MinimalLookahead(prods []Production, tokens map[string]int, maxterm, maxK int, starts ...string) int {
+	for k := 1; k <= maxK; k++ {
+		firsts := ComputeFirstsK(prods, tokens, maxterm, k)
+		follows := ComputeFollowsK(prods, tokens, firsts, maxterm, k, starts...)
+		if _, conflicts := ComputeLLkTable(prods, tokens, firsts, follows, maxterm, k); len(conflicts) == 0 {
+			return k
+		}
+	}
+	return 0
+}
+
+// trieNode is one step of a k-deep decision: prod is set on leaves, next
+// branches on the following word otherwise.
+type trieNode struct {
+	prod int
+	next map[int]*trieNode
+}
+
+// buildTrie turns the predictions of a nonterminal into a trie that looks
+// at no more words than needed to tell the productions apart.
+func buildTrie(predictions []Prediction) *trieNode {
+	root := &trieNode{prod: -1}
+	for _, pred := range predictions {
+		node := root
+		for _, id := range pred.Lookahead {
+			if node.next == nil {
+				node.next = make(map[int]*trieNode)
+			}
+			if _, b := node.next[id]; !b {
+				node.next[id] = &trieNode{prod: -1}
+			}
+			node = node.next[id]
+		}
+		node.prod = pred.Prod
+	}
+	root.prune()
+	return root
+}
+
+// prune turns subtrees that lead to one production into leaves and returns
+// that production, -1 if the subtree is mixed.
+func (self *trieNode) prune() int {
+	if self.next == nil {
+		return self.prod
+	}
+	prod := -2
+	for _, child := range self.next {
+		p := child.prune()
+		if p < 0 || (prod != -2 && p != prod) {
+			prod = -1
+		} else if prod == -2 {
+			prod = p
+		}
+	}
+	if prod >= 0 {
+		self.prod, self.next = prod, nil
+		return prod
+	}
+	return -1
+}
@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+const statements = `
+S : id id ';'
+  | id '=' id ';'
+  | id ';'
+  ;`
+
+func TestComputeFirstsFollowsK(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%%"+`
+S : A B
+  ;
+A : a
+  |
+  ;
+B : b c
+  | c
+  ;`)
+	symbols := grammar.MergeSymbols()
+	firsts := ComputeFirstsK(grammar.prods, symbols, grammar.maxToken, 2)
+	follows := ComputeFollowsK(grammar.prods, symbols, firsts, grammar.maxToken, 2)
+
+	names := func(set LookaheadSet) string {
+		words := make([]string, 0)
+		for _, seq := range set.sorted() {
+			word := make([]string, 0)
+			for _, id := range seq {
+				for name, sid := range symbols {
+					if sid == id {
+						word = append(word, name)
+					}
+				}
+			}
+			words = append(words, strings.Join(word, " "))
+		}
+		sort.Strings(words)
+		return strings.Join(words, ", ")
+	}
+	cases := []struct {
+		set      LookaheadSet
+		expected string
+	}{
+		{firsts["A"], ", a"},
+		{firsts["B"], "b c, c"},
+		{firsts["S"], "a b, a c, b c, c"},
+		{follows["A"], "b c, c $"},
+		{follows["B"], "$"},
+	}
+	for i, c := range cases {
+		if got := names(c.set); got != c.expected {
+			t.Errorf("Case %d: expected {%s}, got {%s}", i, c.expected, got)
+		}
+	}
+}
+
+func TestComputeLLkTable(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%%"+statements)
+	symbols := grammar.MergeSymbols()
+
+	firsts := ComputeFirstsK(grammar.prods, symbols, grammar.maxToken, 1)
+	follows := ComputeFollowsK(grammar.prods, symbols, firsts, grammar.maxToken, 1)
+	if _, conflicts := ComputeLLkTable(grammar.prods, symbols, firsts, follows, grammar.maxToken, 1); len(conflicts) != 2 {
+		t.Errorf("Expected 2 conflicts with one word of lookahead, got %v", conflicts)
+	}
+
+	firsts = ComputeFirstsK(grammar.prods, symbols, grammar.maxToken, 2)
+	follows = ComputeFollowsK(grammar.prods, symbols, firsts, grammar.maxToken, 2)
+	table, conflicts := ComputeLLkTable(grammar.prods, symbols, firsts, follows, grammar.maxToken, 2)
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts with two words of lookahead, got %v", conflicts)
+	}
+	trie := buildTrie(table[symbols["S"]])
+	if trie.next == nil || len(trie.next) != 1 {
+		t.Fatalf("Expected the decision to branch on id only")
+	}
+	second := trie.next[symbols["id"]]
+	for word, prod := range map[string]int{"id": 0, "'='": 1, "';'": 2} {
+		node := second.next[symbols[word]]
+		if node == nil || node.next != nil || node.prod != prod {
+			t.Errorf("Expected id %s to predict production %d, got %v", word, prod, node)
+		}
+	}
+}
+
+func TestComputeLLkTableConflictKinds(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%%\nS : A x\n  ;\nA : B\n  | C\n  ;\nB :\n  ;\nC :\n  ;")
+	symbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, symbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, symbols, firsts, grammar.maxToken)
+	_, conflicts := ComputeLLTable(grammar.prods, symbols, firsts, follows, grammar.maxToken+1, len(symbols)-1)
+	// both empty alternatives are picked on FOLLOW(A), whatever k is
+	for k := 1; k <= 2; k++ {
+		if k > 1 {
+			firstsK := ComputeFirstsK(grammar.prods, symbols, grammar.maxToken, k)
+			followsK := ComputeFollowsK(grammar.prods, symbols, firstsK, grammar.maxToken, k)
+			_, conflicts = ComputeLLkTable(grammar.prods, symbols, firstsK, followsK, grammar.maxToken, k)
+		}
+		if len(conflicts) != 1 || conflicts[0].Kind != FirstFollow {
+			t.Errorf("Expected a FIRST/FOLLOW conflict with k = %d, got %v", k, conflicts)
+		}
+	}
+}
+
+func TestMinimalLookahead(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		content string
+		k       int
+	}{
+		{"S : id\n  | ';'\n  ;", 1},
+		{statements, 2},
+		{"S : id id id\n  | id id ';'\n  ;", 3},
+		{"S : id\n  | id\n  ;", 0},
+	}
+	for _, c := range cases {
+		grammar := parseTestGrammar(t, "%%\n"+c.content)
+		symbols := grammar.MergeSymbols()
+		if k := MinimalLookahead(grammar.prods, symbols, grammar.maxToken, maxLookahead); k != c.k {
+			t.Errorf("Expected k = %d for %q, got %d", c.k, c.content, k)
+		}
+	}
+}
+
+func TestLLParserLookahead(t *testing.T) {
+	grammar := "%package main\n%%" + statements + "\n%%\n"
+	output, err := generate(t, &Generator{Lookahead: 2}, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "var yytrie") {
+		t.Errorf("Expected a decision trie in the generated parser")
+	}
+
+	_, err = generate(t, &Generator{}, grammar)
+	conflictErr, b := err.(*ConflictError)
+	if !b {
+		t.Fatalf("Expected a *ConflictError, got %v", err)
+	}
+	if conflictErr.MinK != 2 {
+		t.Errorf("Expected the grammar to be reported as LL(2), got %d", conflictErr.MinK)
+	}
+	header := "grammar is not LL(1): 2 conflicts, it is LL(2)"
+	if !strings.HasPrefix(err.Error(), header) {
+		t.Errorf("Expected the error to start with %q, got %q", header, err.Error())
+	}
+}
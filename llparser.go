@@ -1,264 +1,383 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strings"
+	"sort"
 )
 
-func LLParser(in *os.File, out *os.File) {
-	content, err := ioutil.ReadAll(in)
+// LLParser reads a grammar from in and writes the generated parser to out.
+// Malformed grammars are reported as *GrammarError, tables with conflicts as
+// *ConflictError unless ConflictPolicy allows them. With a Lookahead above 1
+// the parser decides on up to that many words.
+//
+// The parser recovers from syntax errors. A nonterminal that cannot be
+// predicted is predicted on the error token if it can be, and the error
+// token then skips words until the parse can go on; otherwise words are
+// skipped until it can be predicted or one of its FOLLOW shows up, and it is
+// left out. A missing terminal is assumed. Errors found before a word is
+// matched again are not reported; the others are returned together as
+// SyntaxErrors, along with the value of the repaired parse.
+func (self *Generator) LLParser(in *os.File, out *os.File) error {
+	grammar, scanner, err := self.loadGrammar(in)
 	if err != nil {
-		fmt.Printf("Reading content err: %s\n", err.Error())
-		return
+		return err
 	}
 
-	scanner := &Scanner{content: content, index: 0}
-
-	// init values
-	literalSet = make(map[string]int)
-	tokenSet = make(map[string]int)
-	symbolSet = make(map[string]int)
-	prods = make([]Production, 0)
-	MINTOKEN, MAXTOKEN = 0, 0
-	modules = make([]string, 0)
-	unionTypes = make(map[string]string)
-	termTypes = make(map[string]string)
-	nontermTypes = make(map[string]string)
-
-	ParseHeaders(scanner)
-	ParseGrammars(scanner)
-
-	restCode := scanner.Reminder()
-
-	mergedSymbols := MergeSymbols(literalSet, tokenSet, symbolSet)
-	firsts := ComputeFirsts(prods, mergedSymbols, MAXTOKEN)
-	follows := ComputeFollows(prods, mergedSymbols, firsts)
-	lltable := ComputeLLTable(prods, mergedSymbols, firsts, follows, MAXTOKEN+1, len(mergedSymbols)-1)
+	mergedSymbols := grammar.MergeSymbols()
+	k := self.Lookahead
+	if k < 1 {
+		k = 1
+	}
+	var lltable map[int][]int
+	var predictions map[int][]Prediction
+	var conflicts []Conflict
+	// FOLLOW of one word is what recovery synchronizes on
+	firsts := ComputeFirsts(grammar.prods, mergedSymbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, mergedSymbols, firsts, grammar.maxToken, grammar.startSymbols()...)
+	if k == 1 {
+		lltable, conflicts = ComputeLLTable(grammar.prods, mergedSymbols, firsts, follows, grammar.maxToken+1, len(mergedSymbols)-1)
+	} else {
+		firsts := ComputeFirstsK(grammar.prods, mergedSymbols, grammar.maxToken, k)
+		follows := ComputeFollowsK(grammar.prods, mergedSymbols, firsts, grammar.maxToken, k, grammar.startSymbols()...)
+		predictions, conflicts = ComputeLLkTable(grammar.prods, mergedSymbols, firsts, follows, grammar.maxToken, k)
+	}
+	if len(conflicts) > 0 {
+		if self.ConflictPolicy == FailOnConflict {
+			return &ConflictError{Conflicts: conflicts, K: k,
+				MinK: MinimalLookahead(grammar.prods, mergedSymbols, grammar.maxToken, max(k, maxLookahead), grammar.startSymbols()...)}
+		}
+		for _, conflict := range conflicts {
+			parserLog("Resolved in favour of the first alternative: %s", conflict)
+		}
+	}
 
-	printFile(lltable, mergedSymbols, out)
-	out.Write(restCode)
+	return writeParser(scanner, in, out, func(buf *bytes.Buffer, srcName, outName string) {
+		grammar.printFile(lltable, predictions, follows, mergedSymbols, srcName, outName, buf)
+	})
 }
 
-func printFile(lltable map[int][]int,
+func (self *Grammar) printFile(lltable map[int][]int,
+	predictions map[int][]Prediction,
+	follows map[string][]int,
 	tokens map[string]int,
-	out *os.File) {
-	out.WriteString("// A LL Grammar Parser, writen by Zach41\n// Version 0.1\n\n")
-
-	// package name
-	out.WriteString(fmt.Sprintf("package %s\n\n", packagename))
-	// modules
-	out.WriteString("import (\n")
-	out.WriteString("\t\"fmt\"\n")
-	for _, module := range modules {
-		if module == "fmt" {
-			continue
+	srcName, outName string,
+	out *bytes.Buffer) {
+	self.printPrologue("A LL Grammar Parser", out)
+	out.WriteString("func bodyOfIdx(idx int) []int {\n")
+	out.WriteString("\tbodyIdxes := make([]int, 0)\n")
+	out.WriteString("\tswitch idx {\n")
+	for idx, prod := range self.prods {
+		out.WriteString(fmt.Sprintf("\tcase %d:\n", idx))
+		for _, body := range prod.body {
+			out.WriteString(fmt.Sprintf("\t\tbodyIdxes = append(bodyIdxes, yycharmap[%q])\n", body))
 		}
-		out.WriteString(fmt.Sprintf("\t\"%s\"\n", module))
 	}
-	out.WriteString(")\n\n")
+	out.WriteString("}\n")
+	out.WriteString("\treturn bodyIdxes\n}\n\n")
 
-	out.WriteString("const (\n")
-	out.WriteString(fmt.Sprintf("\tMAXTOKEN = %d\n", MAXTOKEN))
-	out.WriteString(fmt.Sprintf("\tMINTOKEN = %d\n", MINTOKEN))
-	out.WriteString(")\n\n")
-	// write yytype
-	out.WriteString("type yytype struct {\n")
-	for tname, ttype := range unionTypes {
-		out.WriteString(fmt.Sprintf("\t%s    %s\n", tname, ttype))
-	}
-	out.WriteString("}\n\n")
+	printPredictor(out, lltable, predictions)
+	self.printRecovery(lltable, predictions, follows, tokens, out)
 
-	// Stack is a helper struct
-	out.WriteString(`type Stack struct {
-    values [2048]interface{}    // stack size is limited to 2048
-    top    int
-}
+	self.printCharmap(tokens, out)
+	self.printRuncode(srcName, outName, out)
 
-func (stack *Stack) pop() interface{} {
-    if stack.top < 0 {
-        return nil
+	starts := make([]int, 0)
+	for _, start := range self.startSymbols() {
+		starts = append(starts, tokens[start])
+	}
+	self.printEntryPoints(starts, out)
+
+	out.WriteString(`// yyparse parses the nonterminal start from the words nextWord returns.
+// The stack holds the symbols still to match and, below the body of every
+// production being expanded, -prod-1 to run its action once the body is
+// matched.
+func yyparse(start int, nextWord func()(bool, string, *yytype, Span)) (*yytype, error) {
+    values := NewStack[*yytype]()
+    spans := NewStack[Span]()
+    stack := NewStack[int]()
+    expand := func(prod int) {
+        stack.push(-prod - 1)
+        bodyIdxes := bodyOfIdx(prod)
+        for i := len(bodyIdxes) - 1; i >= 0; i-- {
+            stack.push(bodyIdxes[i])
+        }
     }
-    ret := stack.values[stack.top]
-    stack.top -= 1
-    return ret
-}
 
-func (stack *Stack) push(value interface{}) {
-    if stack.top >= 2047 {
-        return
-    } else {
-        stack.top += 1
-        stack.values[stack.top] = value
+    // words read ahead of the parse, the current one first, and how many
+    // were matched before them
+    words := make([]int, 0)
+    texts := make([]string, 0)
+    yyvals := make([]*yytype, 0)
+    yyspans := make([]Span, 0)
+    pos := 0
+    errs := make(SyntaxErrors, 0)
+    recovering := false
+    peek := func(depth int) int {
+        for len(words) <= depth {
+            if len(words) > 0 && words[len(words)-1] == 1 {
+                return 1
+            }
+            eof, word, yyval, span := nextWord()
+            if eof {
+                word = "$"
+            }
+            words = append(words, word2Idx(word))
+            texts = append(texts, word)
+            yyvals = append(yyvals, yyval)
+            yyspans = append(yyspans, span)
+        }
+        return words[depth]
     }
-}
+    skip := func() {
+        words, texts, yyvals, yyspans = words[1:], texts[1:], yyvals[1:], yyspans[1:]
+        pos++
+    }
+    report := func(err *SyntaxError) {
+        if !recovering {
+            errs = append(errs, err)
+        }
+        recovering = true
+    }
+    // missing stands for a symbol left out, empty at the current word
+    missing := func() {
+        peek(0)
+        values.push(&yytype{})
+        spans.push(Span{yyspans[0].Start, yyspans[0].Start})
+    }
+    // follows tells if word may come after the nonterminal top
+    follows := func(top, word int) bool {
+        for _, idx := range yyfollow[top] {
+            if idx == word {
+                return true
+            }
+        }
+        return false
+    }
+    stack.push(start)
 
-func (stack *Stack) empty() bool {
-    return stack.top < 0
-}
+    for !stack.empty() {
+        top := stack.pop()
+        if top < 0 {
+            yyruncode(-top-1, values, spans)
+        } else if top > MAXTOKEN {
+            if yypredict(top, peek) == -1 {
+                depth, expects := yyexpected(top, peek)
+                depth = min(depth, len(words)-1)
+                report(yysyntaxError(pos+depth, yyspans[depth], texts[depth], words[depth], expects))
+                if prod, b := yyerrprods[top]; b {
+                    expand(prod)
+                    continue
+                }
+                for peek(0) != 1 && yypredict(top, peek) == -1 && !follows(top, peek(0)) {
+                    skip()
+                }
+                if yypredict(top, peek) == -1 {
+                    // top is left out, with an empty value
+                    missing()
+                    continue
+                }
+            }
+            expand(yypredict(top, peek))
+        } else if top == yyerrtok {
+            // the error stands for the words up to one the parse can go on
+            // with
+            peek(0)
+            span := Span{yyspans[0].Start, yyspans[0].Start}
+            for peek(0) != 1 && !yycontinues(stack, peek) {
+                span.End = yyspans[0].End
+                skip()
+            }
+            values.push(&yytype{})
+            spans.push(span)
+        } else if top != peek(0) {
+            // top is assumed, with an empty value
+            report(yysyntaxError(pos, yyspans[0], texts[0], words[0], func(idx int) bool { return idx == top }))
+            missing()
+        } else {
+            values.push(yyvals[0])
+            spans.push(yyspans[0])
+            skip()
+            recovering = false
+        }
+    }
 
-func NewStack() *Stack {
-    stack := &Stack{top: -1}
-    return stack
+    if len(errs) > 0 {
+        return values.pop(), errs
+    }
+    return values.pop(), nil
 }
 
-func word2Idx(word string) int {
-    wordIdx := 1
-    if idx, b := yycharmap[word]; b {
-        wordIdx = idx
-    } else {
-        litword := fmt.Sprintf("'%s'", word)
-        if idx, b := yycharmap[litword]; b {
-            wordIdx = idx
-        } else {
-            fmt.Printf("Unrecognized token: %s\n", word)
-            wordIdx = -1
+// yycontinues tells if the parse can go on with the words peek returns
+// once the actions on top of stack run.
+func yycontinues(stack *Stack[int], peek func(int) int) bool {
+    for depth := 0; depth <= stack.top; depth++ {
+        next := stack.peek(depth)
+        switch {
+        case next > MAXTOKEN:
+            return yypredict(next, peek) != -1
+        case next >= 0:
+            return next == peek(0)
         }
     }
-    return wordIdx
+    return peek(0) == 1
 }
 
 `)
-	out.WriteString("func bodyOfIdx(idx int) []int {\n")
-	out.WriteString("\tbodyIdxes := make([]int, 0)\n")
-	out.WriteString("\tswitch idx {\n")
-	for idx, prod := range prods {
-		out.WriteString(fmt.Sprintf("\tcase %d:\n", idx))
-		for _, body := range prod.body {
-			out.WriteString(fmt.Sprintf("\t\tbodyIdxes = append(bodyIdxes, yycharmap[\"%s\"])\n", body))
+
+}
+
+// printRecovery writes what the parser recovers from syntax errors with:
+// yyerrtok, the id of the error token or -1, yyerrprods, the rule predicted
+// on it for every nonterminal that has one, and yyfollow, the FOLLOW of every
+// nonterminal.
+func (self *Grammar) printRecovery(lltable map[int][]int,
+	predictions map[int][]Prediction,
+	follows map[string][]int,
+	tokens map[string]int,
+	out *bytes.Buffer) {
+	errtok, b := tokens["error"]
+	if !b || errtok > self.maxToken {
+		errtok = -1
+	}
+	errprods := make(map[int]int)
+	for row, prods := range lltable {
+		if errtok >= 0 && prods[errtok] != -1 {
+			errprods[row] = prods[errtok]
 		}
 	}
-	out.WriteString("}\n")
-	out.WriteString("\treturn bodyIdxes\n}\n\n")
-
-	// write yytable
-	out.WriteString("var yytable = map[int][]int{\n")
-	for k, row := range lltable {
-		out.WriteString(fmt.Sprintf("\t%d: []int{ ", k))
-		for i, v := range row {
-			if i == len(row)-1 {
-				out.WriteString(fmt.Sprintf("%d },\n", v))
-			} else {
-				out.WriteString(fmt.Sprintf("%d, ", v))
+	for row, preds := range predictions {
+		for _, pred := range preds {
+			if prod, b := errprods[row]; errtok >= 0 && pred.Lookahead[0] == errtok && (!b || pred.Prod < prod) {
+				errprods[row] = pred.Prod
 			}
 		}
 	}
+	rows := make([]int, 0, len(errprods))
+	for row := range errprods {
+		rows = append(rows, row)
+	}
+	sort.Ints(rows)
+	out.WriteString(fmt.Sprintf("const yyerrtok = %d\n\n", errtok))
+	out.WriteString("var yyerrprods = map[int]int{\n")
+	for _, row := range rows {
+		out.WriteString(fmt.Sprintf("\t%d: %d,\n", row, errprods[row]))
+	}
 	out.WriteString("}\n\n")
 
-	// write all symbol mappings
-	out.WriteString("var yycharmap = map[string]int{\n")
-	for name, idx := range tokens {
-		if len(name) == 0 {
-			out.WriteString(fmt.Sprintf("\t\"\": %d,\n", idx))
-		} else {
-			out.WriteString(fmt.Sprintf("\t\"%s\": %d,\n", name, idx))
-		}
+	names := make([]string, 0, len(follows))
+	for name := range follows {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return tokens[names[i]] < tokens[names[j]] })
+	out.WriteString("var yyfollow = map[int][]int{\n")
+	for _, name := range names {
+		follow := append([]int{}, follows[name]...)
+		sort.Ints(follow)
+		out.WriteString(fmt.Sprintf("\t%d: %s,\n", tokens[name], intSlice(follow)))
 	}
 	out.WriteString("}\n\n")
+}
 
-	// running code when reduction happends
-	// idx: which production is reducing, start with 0
-	// values: current values stack
-	// return a yytype value
-	out.WriteString("func yyruncode(idx int, values *Stack) *yytype {\n")
-	out.WriteString("\tlhs := &yytype{}\n")
-	out.WriteString("\tswitch idx {\n")
-	for i, prod := range prods {
-		var codeStr string
-		if len(prod.code) == 0 {
-			codeStr = defaultcode
-		} else {
-			codeStr = prod.code
+// printPredictor writes yypredict, which picks the production expanding
+// nonterminal top given the words peek returns. Without predictions it
+// looks the first word up in yytable, otherwise it walks yytrie.
+func printPredictor(out *bytes.Buffer, lltable map[int][]int, predictions map[int][]Prediction) {
+	if predictions == nil {
+		out.WriteString("var yytable = map[int][]int{\n")
+		rows := make([]int, 0, len(lltable))
+		for k := range lltable {
+			rows = append(rows, k)
 		}
-		parserLog("Original Code:\n%s", codeStr)
-		out.WriteString(fmt.Sprintf("\tcase %d:\n", i))
-		// if len(prod.code) == 0 {
-		// 	out.WriteString("\t\treturn lhs\n")
-		// } else {
-		lhsValue := fmt.Sprintf("\t\tlhs.%s", nontermTypes[prod.name])
-		prodCode := strings.Replace(codeStr, "$$", lhsValue, -1)
-		rhsIdx := 1
-		for _, rhsName := range prod.body {
-			if tokens[rhsName] < MINTOKEN {
-				rhsIdx += 1
-				continue
+		sort.Ints(rows)
+		for _, k := range rows {
+			row := lltable[k]
+			out.WriteString(fmt.Sprintf("\t%d: []int{ ", k))
+			for i, v := range row {
+				if i == len(row)-1 {
+					out.WriteString(fmt.Sprintf("%d },\n", v))
+				} else {
+					out.WriteString(fmt.Sprintf("%d, ", v))
+				}
 			}
-			// out.WriteString("\t\trhs_%d := values.pop().(*yytype)\n")
-			rhsVar := fmt.Sprintf("rhs_%d", rhsIdx)
-			out.WriteString(fmt.Sprintf("\t\t%s := values.pop().(*yytype)\n", rhsVar))
-			var rhsValue string
-			if tokens[rhsName] <= MAXTOKEN {
-				rhsValue = fmt.Sprintf("%s.%s", rhsVar, termTypes[rhsName])
-			} else {
-				rhsValue = fmt.Sprintf("%s.%s", rhsVar, nontermTypes[rhsName])
-			}
-			oldStr := fmt.Sprintf("$%d", rhsIdx)
-			parserLog("Replacing %s to %s in:\n%s", oldStr, rhsValue, prodCode)
-			prodCode = strings.Replace(prodCode, oldStr, rhsValue, -1)
-			rhsIdx += 1
 		}
-		out.WriteString(fmt.Sprintf("\t\t%s\n", prodCode))
-		out.WriteString("\t\tvalues.push(lhs)\n\t\treturn lhs\n")
-		// }
+		out.WriteString("}\n\n")
+		out.WriteString(`func yypredict(top int, peek func(int) int) int {
+    word := peek(0)
+    if word < 0 {
+        return -1
+    }
+    return yytable[top][word]
+}
+
+// yyexpected tells which of the words ahead top could not be predicted on
+// and the words it could have taken there.
+func yyexpected(top int, peek func(int) int) (int, func(int) bool) {
+    return 0, func(idx int) bool { return yytable[top][idx] != -1 }
+}
+
+`)
+		return
 	}
-	out.WriteString("\t}\n\t return lhs\n}\n\n")
 
-	out.WriteString(`func yyparser(nextWord func()(bool, string, *yytype)) *yytype {
-    values := NewStack()
-    prods := NewStack()
-    stack := NewStack()
+	out.WriteString(`type yynode struct {
+    prod int
+    next map[int]*yynode
+}
 
-    eof, word, yyval := nextWord()
-    if eof {
-        word = "$"
+func yypredict(top int, peek func(int) int) int {
+    node := yytrie[top]
+    for depth := 0; node != nil && node.next != nil; depth++ {
+        node = node.next[peek(depth)]
     }
-    wordIdx := word2Idx(word)
-    if wordIdx < 0 {
-        return nil
-    }
-    stack.push(MAXTOKEN + 1)
-
-    for !stack.empty() {
-        top := stack.pop().(int)
-        if top > MAXTOKEN {
-            if yytable[top][wordIdx] == -1 {
-                fmt.Println("Error Parsing")
-                return nil
-            }
-            prods.push(top)
-            bodyIdxes := bodyOfIdx(top)
-            for i := len(bodyIdxes); i>=0; i-- {
-                 stack.push(bodyIdxes[i])
-            }
-        } else {
-            if top != wordIdx {
-                fmt.Println("Not Matching Terminal Or Literal")
-                return nil
-            }
-            if top >= MINTOKEN {
-                values.push(yyval)
-            }
-            eof, word, yyval = nextWord()
-            if eof {
-                word = "$"
-            }
-            wordIdx = word2Idx(word)
-        }
+    if node == nil {
+        return -1
     }
-
-    return yyexecution(values, prods)
+    return node.prod
 }
 
-func yyexecution(values *Stack, prods *Stack) *yytype {
-    var yyval *yytype
-    for !prods.empty() {
-        prod := prods.pop().(int)
-        yyval = yyruncode(prod, values)
+// yyexpected tells which of the words ahead top could not be predicted on
+// and the words it could have taken there.
+func yyexpected(top int, peek func(int) int) (int, func(int) bool) {
+    node, depth := yytrie[top], 0
+    for node.next != nil && node.next[peek(depth)] != nil {
+        node = node.next[peek(depth)]
+        depth++
     }
-    return yyval
+    return depth, func(idx int) bool { return node.next[idx] != nil }
 }
 
 `)
+	out.WriteString("var yytrie = map[int]*yynode{\n")
+	rows := make([]int, 0, len(predictions))
+	for k := range predictions {
+		rows = append(rows, k)
+	}
+	sort.Ints(rows)
+	for _, k := range rows {
+		out.WriteString(fmt.Sprintf("\t%d: ", k))
+		printTrie(out, buildTrie(predictions[k]))
+		out.WriteString(",\n")
+	}
+	out.WriteString("}\n\n")
+}
 
+func printTrie(out *bytes.Buffer, node *trieNode) {
+	if node.next == nil {
+		out.WriteString(fmt.Sprintf("{prod: %d}", node.prod))
+		return
+	}
+	out.WriteString("{prod: -1, next: map[int]*yynode{\n")
+	ids := make([]int, 0, len(node.next))
+	for id := range node.next {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		out.WriteString(fmt.Sprintf("%d: ", id))
+		printTrie(out, node.next[id])
+		out.WriteString(",\n")
+	}
+	out.WriteString("}}")
 }
@@ -1,16 +1,321 @@
 package parser
 
 import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestLLParser(t *testing.T) {
-	in, _ := os.Open("/Users/Zach/go/src/github.com/Zach41/parser/input.y")
-	out, _ := os.Create("yy.output.go")
+	in, err := os.Open("input.y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(t.TempDir(), "yy.output.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
 
-	LLParser(in, out)
+	generator := &Generator{}
+	if err := generator.LLParser(in, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLLParserStart(t *testing.T) {
+	grammar := "%package main\n%start S\n%%\nT : id\n  ;\nS : T ';'\n  ;\n%%\n"
+	output, err := generate(t, &Generator{}, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ';' is 2, id 3, T 4 and S 5
+	for _, part := range []string{"return yyparse(5, nextWord)", "func yyparseS("} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+
+	parsed := parseTestGrammar(t, "%start S\n%%\nT : id\n  ;\nS : T ';'\n  ;")
+	symbols := parsed.MergeSymbols()
+	firsts := ComputeFirsts(parsed.prods, symbols, parsed.maxToken)
+	follows := ComputeFollows(parsed.prods, symbols, firsts, parsed.maxToken, parsed.startSymbols()...)
+	if len(follows["T"]) != 1 || follows["T"][0] != symbols["';'"] || len(follows["S"]) != 1 || follows["S"][0] != 1 {
+		t.Errorf("Expected FOLLOW(T) = {';'} and FOLLOW(S) = {$}, got %v", follows)
+	}
+}
+
+// TestParserSyntaxErrors runs every kind of parser on bad input and checks
+// the SyntaxErrors they return.
+func TestParserSyntaxErrors(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("running the generated parser needs the go tool")
+	}
+	grammar := filepath.Join(t.TempDir(), "errors.y")
+	err = ioutil.WriteFile(grammar, []byte(`%package main
+%import bufio os
+%token id /[a-z]+/
+%skip / +/
+%%
+L : S L
+  |
+  ;
+S : id '=' id ';'
+  ;
+%%
+// every line of the standard input is parsed and its errors printed
+func main() {
+    lines := bufio.NewScanner(os.Stdin)
+    for lines.Scan() {
+        _, err := yyparserSpans(yylexer(lines.Text()))
+        errs, b := err.(SyntaxErrors)
+        if err != nil && !b {
+            fmt.Println("not SyntaxErrors:", err)
+        }
+        for _, e := range errs {
+            s := e.Span
+            fmt.Printf("%d %s %q %d:%d-%d:%d\n", e.Pos, e.Got, e.Expected, s.Start.Line, s.Start.Column, s.End.Line, s.End.Column)
+        }
+    }
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `1 id ["'='"] 1:3-1:4
+6 ';' ["id"] 1:13-1:14
+2 end of input ["id"] 1:4-1:4
+`
+	for _, b := range []goldenBackend{
+		{"LL", (&Generator{}).LLParser},
+		{"LR", (&Generator{}).LRParser},
+		{"GLR", (&Generator{}).GLRParser},
+	} {
+		output := runGenerated(t, gobin, b.backend, grammar, "a = b ;\na b ;\na = b ; c = ;\nx =\n")
+		if output != expected {
+			t.Errorf("%s parser: expected:\n%s\nGot:\n%s", b.name, expected, output)
+		}
+	}
+}
 
-	in.Close()
+func TestLLParserRecovery(t *testing.T) {
+	grammar := "%package main\n%%\nL : S L\n  |\n  ;\nS : id '=' id ';'\n  | error ';'\n  ;\n%%\n"
+	output, err := generate(t, &Generator{}, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// '=' is 2, ';' 3, id 4, error 5, L 6 and S 7
+	for _, part := range []string{"const yyerrtok = 5", "var yyerrprods = map[int]int{\n\t6: 0,\n\t7: 3,\n}",
+		"\t6: []int{1},\n", "\t7: []int{1, 4, 5},\n", "type SyntaxErrors []*SyntaxError"} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+
+	output, err = generate(t, &Generator{}, "%package main\n%%\nS : id\n  ;\n%%\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "const yyerrtok = -1") {
+		t.Errorf("Expected no error token")
+	}
+}
+
+func TestLLParserParallel(t *testing.T) {
+	generator := &Generator{}
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in, err := os.Open("input.y")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer in.Close()
+			out, err := os.CreateTemp(dir, "yy.*.go")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer out.Close()
+			errs[i] = generator.LLParser(in, out)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Generation %d failed: %s", i, err.Error())
+		}
+	}
+}
+
+func TestLLParserLineDirectives(t *testing.T) {
+	dir := t.TempDir()
+	in, err := os.Open("input.y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(dir, "yy.output.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := &Generator{}
+	if err := generator.LLParser(in, out); err != nil {
+		t.Fatal(err)
+	}
 	out.Close()
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "yy.output.go"))
+	lines := strings.Split(string(content), "\n")
+	grammarLines := strings.Split(readFile(t, "input.y"), "\n")
+	srcName := lineFileName("input.y", filepath.Join(dir, "yy.output.go"))
+
+	// grammar lines holding actions, and where the user code starts
+	actionLines := make(map[int]bool)
+	rules, userCode := 0, 0
+	for i, line := range grammarLines {
+		if strings.HasPrefix(line, "%defaultcode") {
+			actionLines[i+1], actionLines[i+2] = true, true
+		}
+		if line == "%%" && rules == 0 {
+			rules = i + 1
+		} else if line == "%%" {
+			userCode = i + 1
+		}
+		if rules > 0 && userCode == 0 && strings.Contains(line, "{") {
+			actionLines[i+1] = true
+		}
+	}
+
+	found := make(map[int]bool)
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "//line ") {
+			continue
+		}
+		sep := strings.LastIndex(line, ":")
+		file, lineno := line[len("//line "):sep], line[sep+1:]
+		n, err := strconv.Atoi(lineno)
+		if err != nil {
+			t.Fatalf("Malformed directive: %s", line)
+		}
+		switch file {
+		case "yy.output.go":
+			if n != i+2 {
+				t.Errorf("Directive on line %d should restore line %d, got %d", i+1, i+2, n)
+			}
+		case srcName:
+			if !actionLines[n] && n < userCode {
+				t.Errorf("Directive %s does not point at an action", line)
+			}
+			if n > userCode {
+				n = userCode
+			}
+			found[n] = true
+		default:
+			t.Errorf("Unexpected directive: %s", line)
+		}
+	}
+	actionLines[userCode] = true
+	for n := range actionLines {
+		if !found[n] {
+			t.Errorf("Expected a directive for grammar line %d: %s", n, grammarLines[n-1])
+		}
+	}
+}
+
+func TestLLParserStableOutput(t *testing.T) {
+	grammar := readFile(t, "input.y")
+	first, err := generate(t, &Generator{}, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := generate(t, &Generator{}, grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatalf("Generation %d differs from the first one", i+1)
+		}
+	}
+	formatted, err := format.Source(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, formatted) {
+		t.Errorf("Generated code is not gofmt-clean")
+	}
+}
+
+func TestLLParserInvalidAction(t *testing.T) {
+	grammar := `%package main
+%union {
+    ival int
+}
+%token<ival> integer
+%type<ival> Num
+%%
+Num : integer           { $$ = $1 + }
+    ;
+%%
+`
+	_, err := generate(t, &Generator{}, grammar)
+	if err == nil {
+		t.Fatal("Expected an error for an action that is not valid Go")
+	}
+	if !strings.Contains(err.Error(), "input.y:8:") {
+		t.Errorf("Expected the error to point at input.y:8, got: %s", err.Error())
+	}
+}
+
+// generate runs the generator on grammar saved as input.y in a temporary
+// directory and returns the generated file.
+func generate(t *testing.T, generator *Generator, grammar string) ([]byte, error) {
+	return generateWith(t, generator.LLParser, grammar)
+}
+
+// generateWith is generate for any of the Generator's backends.
+func generateWith(t *testing.T, backend func(in, out *os.File) error, grammar string) ([]byte, error) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "input.y"), []byte(grammar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(filepath.Join(dir, "input.y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(dir, "yy.output.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := backend(in, out); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out.Name())
+}
+
+func readFile(t *testing.T, name string) string {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
 }
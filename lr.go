@@ -0,0 +1,519 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LRTable is the parse table of an LR automaton. Action rows are indexed by
+// terminal id and hold lrShift or lrReduce codes, 0 for errors. Goto rows
+// are indexed by nonterminal id - maxterm - 1 and hold -1 for no move.
+// Parsing the i-th start symbol begins in state i.
+type LRTable struct {
+	Action [][]int
+	Goto   [][]int
+	// production reduced to accept the first start symbol, the i-th one is
+	// accepted by reducing Accept+i
+	Accept int
+	// reductions that lost a conflict, by state and terminal; GLR parsers
+	// take them as well
+	Dropped map[[2]int][]int
+}
+
+func lrShift(state int) int { return state + 1 }
+func lrReduce(prod int) int { return -prod - 1 }
+
+// lrItem is a production with a dot in front of body[dot].
+type lrItem struct {
+	prod, dot int
+}
+
+// lrState is a state of an LR automaton: its kernel items sorted, the
+// lookaheads of every kernel item and the transitions on symbol ids.
+type lrState struct {
+	kernel     []lrItem
+	lookaheads []map[int]bool
+	gotos      map[int]int
+}
+
+// lrBuilder holds what the LR constructions share: the productions with the
+// augmented start rule appended last, and their symbols as ids.
+type lrBuilder struct {
+	prods    []Production
+	bodies   [][]int
+	lhs      []int
+	byLhs    map[int][]int
+	maxterm  int
+	nsyms    int
+	firsts   map[int]map[int]bool
+	nullable map[int]bool
+	names    map[int]string
+	states   []*lrState
+	nstarts  int
+	// precedences of terminals and productions, Level 0 if none
+	termPrecs []Precedence
+	prodPrecs []Precedence
+}
+
+// lrLookaheadMark stands for the lookaheads to propagate while computing
+// LALR(1) lookaheads.
+const lrLookaheadMark = -1
+
+func newLRBuilder(prods []Production, tokens map[string]int, precs map[string]Precedence, maxterm int, starts []string) *lrBuilder {
+	starts = startSymbols(prods, starts)
+	self := &lrBuilder{
+		prods:    append([]Production{}, prods...),
+		nstarts:  len(starts),
+		byLhs:    make(map[int][]int),
+		maxterm:  maxterm,
+		nsyms:    len(tokens) + 1,
+		firsts:   make(map[int]map[int]bool),
+		nullable: make(map[int]bool),
+		names:    make(map[int]string),
+	}
+	for _, start := range starts {
+		// accepting is reported at the first rule of its start symbol
+		accept := Production{name: "$accept", body: []string{start}}
+		for _, prod := range prods {
+			if prod.name == start {
+				accept.pos = prod.pos
+				break
+			}
+		}
+		self.prods = append(self.prods, accept)
+	}
+	ids := make(map[string]int)
+	for name, id := range tokens {
+		ids[name] = id
+		self.names[id] = name
+	}
+	ids["$accept"] = len(tokens)
+	self.names[len(tokens)] = "$accept"
+
+	for i, prod := range self.prods {
+		body := make([]int, len(prod.body))
+		for n, sym := range prod.body {
+			body[n] = ids[sym]
+		}
+		self.bodies = append(self.bodies, body)
+		self.lhs = append(self.lhs, ids[prod.name])
+		self.byLhs[ids[prod.name]] = append(self.byLhs[ids[prod.name]], i)
+	}
+
+	self.termPrecs = make([]Precedence, maxterm+1)
+	for name, prec := range precs {
+		if id, b := ids[name]; b && id <= maxterm {
+			self.termPrecs[id] = prec
+		}
+	}
+	// a production binds as its %prec token, or else its last token
+	self.prodPrecs = make([]Precedence, len(self.prods))
+	for i, prod := range self.prods {
+		if len(prod.prec) > 0 {
+			self.prodPrecs[i] = precs[prod.prec]
+			continue
+		}
+		for n := len(prod.body) - 1; n >= 0; n-- {
+			if sym := self.bodies[i][n]; self.isTerm(sym) {
+				self.prodPrecs[i] = self.termPrecs[sym]
+				break
+			}
+		}
+	}
+
+	for id := 0; id < self.nsyms; id++ {
+		self.firsts[id] = make(map[int]bool)
+		if id <= maxterm {
+			self.firsts[id][id] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, body := range self.bodies {
+			lhs := self.lhs[i]
+			empty := true
+			for _, sym := range body {
+				for t := range self.firsts[sym] {
+					if !self.firsts[lhs][t] {
+						self.firsts[lhs][t] = true
+						changed = true
+					}
+				}
+				if !self.nullable[sym] {
+					empty = false
+					break
+				}
+			}
+			if empty && !self.nullable[lhs] {
+				self.nullable[lhs] = true
+				changed = true
+			}
+		}
+	}
+	return self
+}
+
+// accept is the first of the productions accepting a start symbol.
+func (self *lrBuilder) accept() int {
+	return len(self.prods) - self.nstarts
+}
+
+// startStates makes the state of every start symbol, with lookahead as
+// the lookaheads of their kernels.
+func (self *lrBuilder) startStates(lookahead map[int]bool) []*lrState {
+	states := make([]*lrState, self.nstarts)
+	for i := range states {
+		set := make(map[int]bool)
+		for t := range lookahead {
+			set[t] = true
+		}
+		states[i] = &lrState{kernel: []lrItem{{self.accept() + i, 0}}, lookaheads: []map[int]bool{set}}
+	}
+	return states
+}
+
+// precedence tells what the precedences of term and prod make of a
+// shift/reduce conflict between them.
+func (self *lrBuilder) precedence(term, prod int) int {
+	tp, pp := self.termPrecs[term], self.prodPrecs[prod]
+	switch {
+	case tp.Level == 0 || pp.Level == 0:
+		return lrUnresolved
+	case pp.Level > tp.Level, pp.Level == tp.Level && tp.Assoc == LeftAssoc:
+		return lrPreferReduce
+	case pp.Level < tp.Level, tp.Assoc == RightAssoc:
+		return lrPreferShift
+	}
+	return lrPreferError
+}
+
+// what precedences make of a shift/reduce conflict
+const (
+	lrUnresolved = iota
+	lrPreferShift
+	lrPreferReduce
+	lrPreferError
+)
+
+func (self *lrBuilder) isTerm(sym int) bool {
+	return sym <= self.maxterm
+}
+
+func (self *lrBuilder) next(item lrItem) (int, bool) {
+	body := self.bodies[item.prod]
+	if item.dot >= len(body) {
+		return 0, false
+	}
+	return body[item.dot], true
+}
+
+func kernelKey(kernel []lrItem) string {
+	parts := make([]string, len(kernel))
+	for i, item := range kernel {
+		parts[i] = fmt.Sprintf("%d.%d", item.prod, item.dot)
+	}
+	return strings.Join(parts, " ")
+}
+
+// closure returns the items of a state with their lookaheads, the kernel
+// first. Lookaheads of added items are FIRST of what follows the
+// nonterminal, plus the lookaheads of the item when that can be empty.
+func (self *lrBuilder) closure(kernel []lrItem, lookaheads []map[int]bool) ([]lrItem, []map[int]bool) {
+	items := append([]lrItem{}, kernel...)
+	sets := make([]map[int]bool, len(kernel))
+	index := make(map[lrItem]int)
+	for i, item := range kernel {
+		sets[i] = make(map[int]bool)
+		for t := range lookaheads[i] {
+			sets[i][t] = true
+		}
+		index[item] = i
+	}
+	work := make([]int, 0, len(items))
+	for i := range items {
+		work = append(work, i)
+	}
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+		sym, b := self.next(items[i])
+		if !b || self.isTerm(sym) {
+			continue
+		}
+		// FIRST of the rest of the body, and whether it can be empty
+		first := make(map[int]bool)
+		empty := true
+		for _, rest := range self.bodies[items[i].prod][items[i].dot+1:] {
+			for t := range self.firsts[rest] {
+				first[t] = true
+			}
+			if !self.nullable[rest] {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			for t := range sets[i] {
+				first[t] = true
+			}
+		}
+		for _, prod := range self.byLhs[sym] {
+			item := lrItem{prod, 0}
+			n, b := index[item]
+			if !b {
+				n = len(items)
+				index[item] = n
+				items = append(items, item)
+				sets = append(sets, make(map[int]bool))
+				work = append(work, n)
+			}
+			changed := false
+			for t := range first {
+				if !sets[n][t] {
+					sets[n][t] = true
+					changed = true
+				}
+			}
+			if changed && b {
+				work = append(work, n)
+			}
+		}
+	}
+	return items, sets
+}
+
+// buildLR0 builds the LR(0) automaton, the states lookaheads are left
+// empty.
+func (self *lrBuilder) buildLR0() {
+	self.states = self.startStates(nil)
+	index := make(map[string]int)
+	for i, start := range self.states {
+		index[kernelKey(start.kernel)] = i
+	}
+	for i := 0; i < len(self.states); i++ {
+		state := self.states[i]
+		items, sets := self.closure(state.kernel, state.lookaheads)
+		state.gotos = make(map[int]int)
+		for _, next := range self.successors(items, sets) {
+			key := kernelKey(next.kernel)
+			target, b := index[key]
+			if !b {
+				target = len(self.states)
+				index[key] = target
+				lookaheads := make([]map[int]bool, len(next.kernel))
+				for n := range lookaheads {
+					lookaheads[n] = make(map[int]bool)
+				}
+				self.states = append(self.states, &lrState{kernel: next.kernel, lookaheads: lookaheads})
+			}
+			state.gotos[next.sym] = target
+		}
+	}
+}
+
+// lrSuccessor is the kernel reached from a state on sym.
+type lrSuccessor struct {
+	sym        int
+	kernel     []lrItem
+	lookaheads []map[int]bool
+}
+
+// successors groups the items of a state by the symbol after their dot, in
+// the order of symbol ids.
+func (self *lrBuilder) successors(items []lrItem, sets []map[int]bool) []lrSuccessor {
+	bySym := make(map[int]*lrSuccessor)
+	syms := make([]int, 0)
+	for i, item := range items {
+		sym, b := self.next(item)
+		if !b {
+			continue
+		}
+		if _, b := bySym[sym]; !b {
+			bySym[sym] = &lrSuccessor{sym: sym}
+			syms = append(syms, sym)
+		}
+		next := bySym[sym]
+		next.kernel = append(next.kernel, lrItem{item.prod, item.dot + 1})
+		next.lookaheads = append(next.lookaheads, sets[i])
+	}
+	sort.Ints(syms)
+	result := make([]lrSuccessor, 0, len(syms))
+	for _, sym := range syms {
+		next := bySym[sym]
+		order := make([]int, len(next.kernel))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			a, b := next.kernel[order[i]], next.kernel[order[j]]
+			if a.prod != b.prod {
+				return a.prod < b.prod
+			}
+			return a.dot < b.dot
+		})
+		sorted := lrSuccessor{sym: sym}
+		for _, i := range order {
+			sorted.kernel = append(sorted.kernel, next.kernel[i])
+			sorted.lookaheads = append(sorted.lookaheads, next.lookaheads[i])
+		}
+		result = append(result, sorted)
+	}
+	return result
+}
+
+// kernelIndex finds item in the kernel of a state.
+func (self *lrState) kernelIndex(item lrItem) int {
+	for i, k := range self.kernel {
+		if k == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// computeLALR fills the lookaheads of the LR(0) states: the ones a closure
+// generates spontaneously, then the ones propagated from kernel to kernel.
+func (self *lrBuilder) computeLALR() {
+	type link struct{ state, item int }
+	propagate := make(map[link][]link)
+	for i := 0; i < self.nstarts; i++ {
+		self.states[i].lookaheads[0][1] = true
+	}
+	for i, state := range self.states {
+		for n, item := range state.kernel {
+			mark := []map[int]bool{{lrLookaheadMark: true}}
+			items, sets := self.closure([]lrItem{item}, mark)
+			for m, citem := range items {
+				sym, b := self.next(citem)
+				if !b {
+					continue
+				}
+				target := self.states[state.gotos[sym]]
+				to := link{state.gotos[sym], target.kernelIndex(lrItem{citem.prod, citem.dot + 1})}
+				for t := range sets[m] {
+					if t == lrLookaheadMark {
+						propagate[link{i, n}] = append(propagate[link{i, n}], to)
+					} else {
+						target.lookaheads[to.item][t] = true
+					}
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for from, tos := range propagate {
+			for t := range self.states[from.state].lookaheads[from.item] {
+				for _, to := range tos {
+					set := self.states[to.state].lookaheads[to.item]
+					if !set[t] {
+						set[t] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// table builds the actions of every state. When actions clash, shifting wins
+// over reducing and the production written first over later ones, as yacc
+// does, and the clash is returned as a Conflict.
+func (self *lrBuilder) table() (*LRTable, []Conflict) {
+	table := &LRTable{Accept: self.accept(), Dropped: make(map[[2]int][]int)}
+	conflicts := make([]Conflict, 0)
+	nnonterms := self.nsyms - self.maxterm - 2
+	for i, state := range self.states {
+		action := make([]int, self.maxterm+1)
+		gotos := make([]int, nnonterms)
+		for n := range gotos {
+			gotos[n] = -1
+		}
+		for sym, target := range state.gotos {
+			if self.isTerm(sym) {
+				action[sym] = lrShift(target)
+			} else if sym-self.maxterm-1 < nnonterms {
+				gotos[sym-self.maxterm-1] = target
+			}
+		}
+
+		items, sets := self.closure(state.kernel, state.lookaheads)
+		shifted := make(map[int]int)
+		reduces := make([]int, 0)
+		lookaheads := make(map[int]map[int]bool)
+		for n, item := range items {
+			sym, b := self.next(item)
+			if b {
+				if _, seen := shifted[sym]; !seen && self.isTerm(sym) {
+					shifted[sym] = item.prod
+				}
+				continue
+			}
+			if _, seen := lookaheads[item.prod]; !seen {
+				reduces = append(reduces, item.prod)
+				lookaheads[item.prod] = make(map[int]bool)
+			}
+			for t := range sets[n] {
+				lookaheads[item.prod][t] = true
+			}
+		}
+		sort.Ints(reduces)
+		reducer := make(map[int]int)
+		nonassoc := make(map[int]bool)
+		for _, prod := range reduces {
+			terms := make([]int, 0, len(lookaheads[prod]))
+			for t := range lookaheads[prod] {
+				terms = append(terms, t)
+			}
+			sort.Ints(terms)
+			for _, t := range terms {
+				cur := action[t]
+				if cur > 0 {
+					switch self.precedence(t, prod) {
+					case lrPreferShift:
+						continue
+					case lrPreferReduce:
+						cur = 0
+					case lrPreferError:
+						action[t] = 0
+						nonassoc[t] = true
+						continue
+					}
+				}
+				switch {
+				case nonassoc[t]:
+				case cur == 0:
+					action[t] = lrReduce(prod)
+					reducer[t] = prod
+				case cur > 0:
+					conflicts = append(conflicts, self.conflict(ShiftReduce, i, t, shifted[t], prod))
+					table.Dropped[[2]int{i, t}] = append(table.Dropped[[2]int{i, t}], lrReduce(prod))
+				default:
+					conflicts = append(conflicts, self.conflict(ReduceReduce, i, t, reducer[t], prod))
+					table.Dropped[[2]int{i, t}] = append(table.Dropped[[2]int{i, t}], lrReduce(prod))
+				}
+			}
+		}
+		table.Action = append(table.Action, action)
+		table.Goto = append(table.Goto, gotos)
+	}
+	return table, conflicts
+}
+
+func (self *lrBuilder) conflict(kind ConflictKind, state, term, kept, other int) Conflict {
+	return Conflict{
+		Kind:      kind,
+		Nonterm:   self.prods[other].name,
+		Lookahead: self.names[term],
+		State:     state,
+		Prods:     [2]int{kept, other},
+		Bodies:    [2]Production{self.prods[kept], self.prods[other]},
+	}
+}
+
+// ComputeLALRTable builds the LALR(1) table of the productions without
+// precedences, starting as ComputeLRTable does.
+func ComputeLALRTable(prods []Production, tokens map[string]int, maxterm int, starts ...string) (*LRTable, []Conflict) {
+	return ComputeLRTable(LALR, prods, tokens, nil, maxterm, starts...)
+}
@@ -0,0 +1,267 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LRMethod selects how LRParser builds its automaton.
+type LRMethod int
+
+const (
+	// LALR merges all LR(1) states made of the same items
+	LALR LRMethod = iota
+	// CanonicalLR keeps every LR(1) state apart
+	CanonicalLR
+	// IELR merges LR(1) states as LALR does, unless that adds conflicts
+	IELR
+)
+
+func (method LRMethod) String() string {
+	switch method {
+	case LALR:
+		return "LALR(1)"
+	case CanonicalLR:
+		return "LR(1)"
+	case IELR:
+		return "IELR(1)"
+	}
+	return "unknown"
+}
+
+// ComputeLRTable builds the table of the productions with the given method
+// for the start symbols, the first production's name unless given.
+// Shift/reduce conflicts are resolved by precs where they tell.
+func ComputeLRTable(method LRMethod, prods []Production, tokens map[string]int, precs map[string]Precedence, maxterm int, starts ...string) (*LRTable, []Conflict) {
+	builder := newLRBuilder(prods, tokens, precs, maxterm, starts)
+	switch method {
+	case CanonicalLR:
+		builder.buildLR1()
+	case IELR:
+		builder.buildIELR()
+	default:
+		builder.buildLR0()
+		builder.computeLALR()
+	}
+	return builder.table()
+}
+
+func lr1Key(kernel []lrItem, lookaheads []map[int]bool) string {
+	parts := make([]string, len(kernel))
+	for i := range kernel {
+		terms := make([]int, 0, len(lookaheads[i]))
+		for t := range lookaheads[i] {
+			terms = append(terms, t)
+		}
+		sort.Ints(terms)
+		parts[i] = fmt.Sprint(terms)
+	}
+	return kernelKey(kernel) + " | " + strings.Join(parts, " ")
+}
+
+// buildLR1 builds the canonical LR(1) automaton, where states with the same
+// items but other lookaheads stay apart.
+func (self *lrBuilder) buildLR1() {
+	self.states = self.startStates(map[int]bool{1: true})
+	index := make(map[string]int)
+	for i, start := range self.states {
+		index[lr1Key(start.kernel, start.lookaheads)] = i
+	}
+	for i := 0; i < len(self.states); i++ {
+		state := self.states[i]
+		items, sets := self.closure(state.kernel, state.lookaheads)
+		state.gotos = make(map[int]int)
+		for _, next := range self.successors(items, sets) {
+			key := lr1Key(next.kernel, next.lookaheads)
+			target, b := index[key]
+			if !b {
+				target = len(self.states)
+				index[key] = target
+				self.states = append(self.states, &lrState{kernel: next.kernel, lookaheads: next.lookaheads})
+			}
+			state.gotos[next.sym] = target
+		}
+	}
+}
+
+// buildIELR starts from the canonical LR(1) automaton and merges the states
+// with the same items unless that adds conflicts. Merged states must move
+// to merged states on every symbol, so classes are split until they do.
+func (self *lrBuilder) buildIELR() {
+	self.buildLR1()
+	canonical := self.states
+	conflicts := make([]map[int]bool, len(canonical))
+	for i, state := range canonical {
+		conflicts[i] = self.conflictTerms(state.kernel, state.lookaheads)
+	}
+	compatible := func(members []int) bool {
+		known := make(map[int]bool)
+		for _, s := range members {
+			for t := range conflicts[s] {
+				known[t] = true
+			}
+		}
+		merged := self.mergeStates(canonical, members)
+		for t := range self.conflictTerms(merged.kernel, merged.lookaheads) {
+			if !known[t] {
+				return false
+			}
+		}
+		return true
+	}
+
+	class := make([]int, len(canonical))
+	members := make([][]int, 0)
+	byCore := make(map[string][]int)
+	for i, state := range canonical {
+		core := kernelKey(state.kernel)
+		class[i] = -1
+		for _, c := range byCore[core] {
+			if compatible(append(append([]int{}, members[c]...), i)) {
+				members[c] = append(members[c], i)
+				class[i] = c
+				break
+			}
+		}
+		if class[i] < 0 {
+			class[i] = len(members)
+			byCore[core] = append(byCore[core], class[i])
+			members = append(members, []int{i})
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for c := 0; c < len(members); c++ {
+			// members moving to the same classes stay together
+			groups := make(map[string][]int)
+			order := make([]string, 0)
+			for _, s := range members[c] {
+				syms := make([]int, 0, len(canonical[s].gotos))
+				for sym := range canonical[s].gotos {
+					syms = append(syms, sym)
+				}
+				sort.Ints(syms)
+				sig := ""
+				for _, sym := range syms {
+					sig += fmt.Sprintf("%d:%d ", sym, class[canonical[s].gotos[sym]])
+				}
+				if _, b := groups[sig]; !b {
+					order = append(order, sig)
+				}
+				groups[sig] = append(groups[sig], s)
+			}
+			if len(order) == 1 {
+				continue
+			}
+			changed = true
+			members[c] = groups[order[0]]
+			for _, sig := range order[1:] {
+				for _, s := range groups[sig] {
+					class[s] = len(members)
+				}
+				members = append(members, groups[sig])
+			}
+		}
+		if changed {
+			continue
+		}
+		// splitting may have left a class whose merge adds conflicts
+		for c := 0; c < len(members); c++ {
+			if len(members[c]) > 1 && !compatible(members[c]) {
+				changed = true
+				for _, s := range members[c][1:] {
+					class[s] = len(members)
+					members = append(members, []int{s})
+				}
+				members[c] = members[c][:1]
+			}
+		}
+	}
+
+	states := make([]*lrState, len(members))
+	for c := range members {
+		states[c] = self.mergeStates(canonical, members[c])
+		states[c].gotos = make(map[int]int)
+		for sym, target := range canonical[members[c][0]].gotos {
+			states[c].gotos[sym] = class[target]
+		}
+	}
+	self.states = states
+}
+
+// mergeStates unites the lookaheads of states made of the same items.
+func (self *lrBuilder) mergeStates(states []*lrState, members []int) *lrState {
+	first := states[members[0]]
+	merged := &lrState{kernel: first.kernel, lookaheads: make([]map[int]bool, len(first.kernel))}
+	for n := range merged.lookaheads {
+		merged.lookaheads[n] = make(map[int]bool)
+		for _, s := range members {
+			for t := range states[s].lookaheads[n] {
+				merged.lookaheads[n][t] = true
+			}
+		}
+	}
+	return merged
+}
+
+// conflictTerms returns the terminals on which a state could take more than
+// one action.
+func (self *lrBuilder) conflictTerms(kernel []lrItem, lookaheads []map[int]bool) map[int]bool {
+	items, sets := self.closure(kernel, lookaheads)
+	shifts := make(map[int]bool)
+	reduces := make(map[int]int)
+	resolved := make(map[int]bool)
+	for n, item := range items {
+		if sym, b := self.next(item); b {
+			if self.isTerm(sym) {
+				shifts[sym] = true
+			}
+			continue
+		}
+		for t := range sets[n] {
+			reduces[t]++
+			if self.precedence(t, item.prod) != lrUnresolved {
+				resolved[t] = true
+			}
+		}
+	}
+	terms := make(map[int]bool)
+	for t, count := range reduces {
+		if count > 1 || shifts[t] && !resolved[t] {
+			terms[t] = true
+		}
+	}
+	return terms
+}
+
+// TableStats sums up a parse table: Size counts states for LR tables and
+// rows for the LL(1) table.
+type TableStats struct {
+	Table     string
+	Size      int
+	Conflicts int
+}
+
+func (self TableStats) String() string {
+	unit := "states"
+	if strings.HasPrefix(self.Table, "LL") {
+		unit = "rows"
+	}
+	return fmt.Sprintf("%s: %d %s, %d conflicts", self.Table, self.Size, unit, self.Conflicts)
+}
+
+// CompareTables builds the LL(1) table and the table of every LRMethod for
+// the productions, to weigh their sizes against each other.
+func CompareTables(prods []Production, tokens map[string]int, precs map[string]Precedence, maxterm int, starts ...string) []TableStats {
+	firsts := ComputeFirsts(prods, tokens, maxterm)
+	follows := ComputeFollows(prods, tokens, firsts, maxterm, starts...)
+	lltable, conflicts := ComputeLLTable(prods, tokens, firsts, follows, maxterm+1, len(tokens)-1)
+	stats := []TableStats{{Table: "LL(1)", Size: len(lltable), Conflicts: len(conflicts)}}
+	for _, method := range []LRMethod{LALR, CanonicalLR, IELR} {
+		table, conflicts := ComputeLRTable(method, prods, tokens, precs, maxterm, starts...)
+		stats = append(stats, TableStats{Table: method.String(), Size: len(table.Action), Conflicts: len(conflicts)})
+	}
+	return stats
+}
@@ -0,0 +1,459 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const expressions = `
+%union {
+    v string
+}
+%token<v> id
+%type<v> E T F
+%%
+E : E '+' T      { $$ = add($1,$3) }
+  | T            { $$ = $1 }
+  ;
+T : T '*' F      { $$ = mul($1,$3) }
+  | F            { $$ = $1 }
+  ;
+F : '(' E ')'    { $$ = $2 }
+  | id           { $$ = $1 }
+  ;
+`
+
+func TestComputeLALRTable(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, expressions)
+	symbols := grammar.MergeSymbols()
+	table, conflicts := ComputeLALRTable(grammar.prods, symbols, grammar.maxToken)
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v", conflicts)
+	}
+	if len(table.Action) != 12 {
+		t.Errorf("Expected 12 states, got %d", len(table.Action))
+	}
+	cases := map[string]string{
+		"id":                       "id",
+		"id '+' id '+' id":         "add(add(id,id),id)",
+		"id '+' id '*' id":         "add(id,mul(id,id))",
+		"'(' id '+' id ')' '*' id": "mul(add(id,id),id)",
+		"id '*' id '*' id '+' id":  "add(mul(mul(id,id),id),id)",
+	}
+	for input, expected := range cases {
+		if value := evalLR(t, grammar, table, input); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, value)
+		}
+	}
+}
+
+func TestComputeLALRTableLookaheads(t *testing.T) {
+	t.Parallel()
+	// not SLR(1): FOLLOW(R) holds '=', yet only LALR(1) lookaheads tell
+	// L '=' R from R
+	grammar := parseTestGrammar(t, `%%
+S : L '=' R
+  | R
+  ;
+L : '*' R
+  | id
+  ;
+R : L
+  ;`)
+	symbols := grammar.MergeSymbols()
+	if _, conflicts := ComputeLALRTable(grammar.prods, symbols, grammar.maxToken); len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+	}
+}
+
+func TestComputeLALRTableConflicts(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `
+%union {
+    v string
+}
+%token<v> id
+%type<v> E
+%%
+E : E '+' E      { $$ = add($1,$3) }
+  | id           { $$ = $1 }
+  ;`)
+	symbols := grammar.MergeSymbols()
+	table, conflicts := ComputeLALRTable(grammar.prods, symbols, grammar.maxToken)
+	if len(conflicts) != 1 || conflicts[0].Kind != ShiftReduce {
+		t.Fatalf("Expected one shift/reduce conflict, got %v", conflicts)
+	}
+	report := "8:3: shift/reduce conflict in state 4 on '+': [0] E : E '+' E clashes with [0] E : E '+' E at 8:3"
+	if conflicts[0].String() != report {
+		t.Errorf("Expected report:\n\t%s\nGot:\n\t%s", report, conflicts[0])
+	}
+	// shifting makes '+' right associative
+	if value := evalLR(t, grammar, table, "id '+' id '+' id"); value != "add(id,add(id,id))" {
+		t.Errorf("Expected the conflict to be resolved by shifting, got %s", value)
+	}
+	if dropped := table.Dropped[[2]int{4, symbols["'+'"]}]; len(dropped) != 1 || dropped[0] != lrReduce(0) {
+		t.Errorf("Expected the reduction losing to be kept for GLR, got %v", table.Dropped)
+	}
+
+	grammar = parseTestGrammar(t, "%%\nS : A x\n  | B x\n  ;\nA : id\n  ;\nB : id\n  ;")
+	symbols = grammar.MergeSymbols()
+	_, conflicts = ComputeLALRTable(grammar.prods, symbols, grammar.maxToken)
+	if len(conflicts) != 1 || conflicts[0].Kind != ReduceReduce || conflicts[0].Prods != [2]int{2, 3} {
+		t.Errorf("Expected a reduce/reduce conflict kept for A, got %v", conflicts)
+	}
+
+	// accepting S clashes with reducing it to T
+	grammar = parseTestGrammar(t, "%%\nS : T\n  | id\n  ;\nT : S\n  ;")
+	symbols = grammar.MergeSymbols()
+	table, conflicts = ComputeLALRTable(grammar.prods, symbols, grammar.maxToken)
+	if len(conflicts) != 1 || conflicts[0].Kind != ReduceReduce || conflicts[0].Prods != [2]int{2, table.Accept} {
+		t.Fatalf("Expected a reduce/reduce conflict with accepting, got %v", conflicts)
+	}
+	report = "2:3: reduce/reduce conflict in state 2 on $: [3] $accept : S clashes with [2] T : S at 5:3"
+	if conflicts[0].String() != report {
+		t.Errorf("Expected report:\n\t%s\nGot:\n\t%s", report, conflicts[0])
+	}
+	if dropped := table.Dropped[[2]int{conflicts[0].State, 1}]; len(dropped) != 1 || dropped[0] != lrReduce(table.Accept) {
+		t.Errorf("Expected accepting to be dropped, got %v", table.Dropped)
+	}
+}
+
+// notLALR is LR(1), yet merging the states reached on 'c' makes A and B
+// clash
+const notLALR = `%%
+S : 'a' A 'd'
+  | 'b' B 'd'
+  | 'a' B 'e'
+  | 'b' A 'e'
+  ;
+A : 'c'
+  ;
+B : 'c'
+  ;`
+
+func TestComputeLRTable(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, expressions)
+	symbols := grammar.MergeSymbols()
+	lalr, _ := ComputeLRTable(LALR, grammar.prods, symbols, nil, grammar.maxToken)
+	for _, method := range []LRMethod{CanonicalLR, IELR} {
+		table, conflicts := ComputeLRTable(method, grammar.prods, symbols, nil, grammar.maxToken)
+		if len(conflicts) != 0 {
+			t.Fatalf("%s: expected no conflicts, got %v", method, conflicts)
+		}
+		if value := evalLR(t, grammar, table, "id '*' '(' id '+' id ')'"); value != "mul(id,add(id,id))" {
+			t.Errorf("%s: expected mul(id,add(id,id)), got %s", method, value)
+		}
+		if method == CanonicalLR && len(table.Action) <= len(lalr.Action) {
+			t.Errorf("Expected LR(1) to have more states than LALR(1), got %d", len(table.Action))
+		}
+		if method == IELR && len(table.Action) != len(lalr.Action) {
+			t.Errorf("Expected IELR(1) to have as many states as LALR(1), got %d", len(table.Action))
+		}
+	}
+
+	grammar = parseTestGrammar(t, notLALR)
+	symbols = grammar.MergeSymbols()
+	if _, conflicts := ComputeLRTable(LALR, grammar.prods, symbols, nil, grammar.maxToken); len(conflicts) != 2 {
+		t.Errorf("Expected 2 LALR(1) conflicts, got %v", conflicts)
+	}
+	stats := CompareTables(grammar.prods, symbols, nil, grammar.maxToken)
+	sizes := make(map[string]TableStats)
+	for _, stat := range stats {
+		sizes[stat.Table] = stat
+	}
+	if ielr := sizes["IELR(1)"]; ielr.Conflicts != 0 || ielr.Size != sizes["LALR(1)"].Size+1 {
+		t.Errorf("Expected IELR(1) to split one LALR(1) state, got %s", ielr)
+	}
+	if lr1 := sizes["LR(1)"]; lr1.Conflicts != 0 || lr1.Size != sizes["IELR(1)"].Size {
+		t.Errorf("Expected LR(1) to need no more states than IELR(1), got %s", lr1)
+	}
+	table, _ := ComputeLRTable(IELR, grammar.prods, symbols, nil, grammar.maxToken)
+	for _, input := range []string{"'a' 'c' 'd'", "'a' 'c' 'e'", "'b' 'c' 'd'", "'b' 'c' 'e'"} {
+		evalLR(t, grammar, table, input)
+	}
+}
+
+func TestLRParser(t *testing.T) {
+	grammar := "%package main\n" + expressions + "%%\nfunc add(a, b string) string { return a + b }\n" +
+		"func mul(a, b string) string { return a + b }\n"
+	output, err := generateLR(t, &Generator{}, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"var yyaction", "var yygoto", "func yyruncode", "func yyparser",
+		"(*yytype, error)", "type SyntaxError struct", "return nil, SyntaxErrors{yysyntaxError(pos, span, word, wordIdx, expects)}"} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+
+	_, err = generateLR(t, &Generator{}, "%package main\n%%\nE : E '+' E\n  | id\n  ;\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "grammar is not LALR(1): 1 conflicts") {
+		t.Errorf("Expected a LALR(1) conflict error, got %v", err)
+	}
+	if _, err := generateLR(t, &Generator{ConflictPolicy: PreferFirst}, "%package main\n%%\nE : E '+' E\n  | id\n  ;\n"); err != nil {
+		t.Errorf("Expected PreferFirst to resolve the conflict, got %v", err)
+	}
+	if _, err := generateLR(t, &Generator{}, "%package main\n%left '+'\n%%\nE : E '+' E\n  | id\n  ;\n"); err != nil {
+		t.Errorf("Expected %%left to resolve the conflict, got %v", err)
+	}
+
+	output, err = generateLR(t, &Generator{}, "%package main\n%start T E\n"+expressions+"%%\n"+
+		"func add(a, b string) string { return a + b }\nfunc mul(a, b string) string { return a + b }\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"return yyparse(0, nextWord)", "func yyparseT(", "func yyparseE(", "return yyparse(1, nextWord)"} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+
+	var report bytes.Buffer
+	_, err = generateLR(t, &Generator{LRMethod: IELR, StateReport: &report}, "%package main\n"+notLALR+"\n%%\n")
+	if err != nil {
+		t.Errorf("Expected the IELR(1) table to have no conflicts, got %v", err)
+	}
+	if !strings.Contains(report.String(), "LALR(1): ") || !strings.Contains(report.String(), "LL(1): ") {
+		t.Errorf("Expected the size of every table in the report, got:\n%s", report.String())
+	}
+}
+
+func TestLRParserTypedValues(t *testing.T) {
+	rest := "%%\nfunc add(a, b string) string { return a + b }\nfunc mul(a, b string) string { return a + b }\n"
+	output, err := generateLR(t, &Generator{TypedValues: true}, "%package main\n"+expressions+rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"rhs_3 := values.pop().v", "var lhs string", "lhs = add(rhs_1, rhs_3)", "yyval := &yytype{v: lhs}"} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+	if strings.Contains(string(output), "interface{}") {
+		t.Errorf("Expected no value to be boxed")
+	}
+
+	for grammar, expected := range map[string]string{
+		"%union {\n    v string\n    n int\n}\n%token<v> id\n%type<v> E\n%%\nE : id { $$ = $<n>1 }\n  ;\n":   "input.y:9:15: $<n>1 does not match the type <v> of id in rule E",
+		"%union {\n    v string\n}\n%token<v> id\n%type<v> E\n%%\nE : F { $$ = $1 }\n  ;\nF : id { }\n  ;\n": "input.y:8:14: $1 refers to F, which has no type in rule E",
+		"%union {\n    v string\n}\n%token<v> id\n%%\nE : id { $$ = $1 }\n  ;\n":                             "input.y:7:10: $$ refers to E, which has no type in rule E",
+		"%union {\n    v string\n}\n%token<v> id\n%type<v> E\n%%\nE : id { $$ = $0 }\n  ;\n":                 "input.y:8:15: $0 needs a <field> in rule E",
+	} {
+		_, err := generateLR(t, &Generator{TypedValues: true}, "%package main\n"+grammar+"%%\n")
+		if err == nil || !strings.HasSuffix(err.Error(), expected) {
+			t.Errorf("Expected %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestComputeLRTableStarts(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, expressions)
+	symbols := grammar.MergeSymbols()
+	for _, method := range []LRMethod{LALR, CanonicalLR, IELR} {
+		table, conflicts := ComputeLRTable(method, grammar.prods, symbols, nil, grammar.maxToken, "T", "E")
+		if len(conflicts) != 0 {
+			t.Fatalf("%s: expected no conflicts, got %v", method, conflicts)
+		}
+		if value, err := runLR(grammar, table, 0, "id '*' id"); value != "mul(id,id)" {
+			t.Errorf("%s: expected T to parse id '*' id, got %s %v", method, value, err)
+		}
+		if _, err := runLR(grammar, table, 0, "id '+' id"); err == nil {
+			t.Errorf("%s: expected T not to parse id '+' id", method)
+		}
+		if value, err := runLR(grammar, table, 1, "id '+' id"); value != "add(id,id)" {
+			t.Errorf("%s: expected E to parse id '+' id, got %s %v", method, value, err)
+		}
+	}
+}
+
+func TestComputeLRTablePrecedence(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `
+%union {
+    v string
+}
+%token<v> id
+%type<v> E
+%nonassoc '<'
+%left '+' '-'
+%left '*'
+%right '^'
+%left uminus
+%%
+E : E '<' E      { $$ = lt($1,$3) }
+  | E '+' E      { $$ = add($1,$3) }
+  | E '-' E      { $$ = sub($1,$3) }
+  | E '*' E      { $$ = mul($1,$3) }
+  | E '^' E      { $$ = pow($1,$3) }
+  | '-' E %prec uminus { $$ = neg($2) }
+  | id           { $$ = $1 }
+  ;`)
+	symbols := grammar.MergeSymbols()
+	table, conflicts := ComputeLRTable(LALR, grammar.prods, symbols, grammar.precs, grammar.maxToken)
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected precedences to resolve every conflict, got %v", conflicts)
+	}
+	cases := map[string]string{
+		"id '+' id '*' id":        "add(id,mul(id,id))",
+		"id '*' id '+' id":        "add(mul(id,id),id)",
+		"id '-' id '-' id":        "sub(sub(id,id),id)",
+		"id '^' id '^' id":        "pow(id,pow(id,id))",
+		"'-' id '*' id":           "mul(neg(id),id)",
+		"'-' id '^' id":           "pow(neg(id),id)",
+		"id '+' id '<' id '*' id": "lt(add(id,id),mul(id,id))",
+		"id '-' '-' id '+' id":    "add(sub(id,neg(id)),id)",
+	}
+	for input, expected := range cases {
+		if value := evalLR(t, grammar, table, input); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, value)
+		}
+	}
+	if _, err := runLR(grammar, table, 0, "id '<' id '<' id"); err == nil {
+		t.Errorf("Expected '<' not to associate")
+	}
+
+	for _, c := range []struct{ content, msg string }{
+		{"%left '+'\n%right '+'\n%%\nE : id\n  ;", "2:8: precedence of '+' declared twice"},
+		{"%left E\n%%\nE : id\n  ;", "1:7: expected token after %left, got \"E\""},
+		{"%%\nE : id %prec '+'\n  ;", "2:14: %prec '+' has no precedence"},
+	} {
+		grammar := NewGrammar()
+		scanner := &Scanner{content: []byte(c.content)}
+		err := grammar.ParseHeaders(scanner)
+		if err == nil {
+			err = grammar.ParseGrammars(scanner)
+		}
+		if err == nil || err.Error() != c.msg {
+			t.Errorf("Expected %q, got %v", c.msg, err)
+		}
+	}
+}
+
+func TestGLRParser(t *testing.T) {
+	grammar := "%package main\n%union {\n    v string\n}\n%token<v> id\n%type<v> E\n%%\n" +
+		"E : E '+' E { $$ = $1 + $3 }\n  | E '*' E { $$ = $1 + $3 }\n  | id\n  ;\n%%\n"
+	output, err := generateWith(t, (&Generator{}).GLRParser, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"var yydropped", "type yysppf", "var yyambiguity", "func yyglr", "func yyparser"} {
+		if !strings.Contains(string(output), part) {
+			t.Errorf("Expected %q in the generated parser", part)
+		}
+	}
+	if !strings.Contains(string(output), "{6, 2}: []int{-2}") {
+		t.Errorf("Expected the reductions of E '*' E to be kept on '+'")
+	}
+}
+
+func TestGLRParserAmbiguity(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("running the generated parser needs the go tool")
+	}
+	grammar := filepath.Join(t.TempDir(), "ambiguous.y")
+	err = ioutil.WriteFile(grammar, []byte(`%package main
+%import bufio os
+%union {
+    v string
+}
+%token<v> id /[a-z]+/
+%skip / +/
+%type<v> E
+%%
+E : E '+' E { $$ = "(" + $1 + " + " + $3 + ")" }
+  | E '*' E { $$ = "(" + $1 + " * " + $3 + ")" }
+  | id      { $$ = $1 }
+  ;
+%%
+// every line of the standard input is parsed with the first derivation, the
+// last one and an index out of range
+func main() {
+    lines := bufio.NewScanner(os.Stdin)
+    for lines.Scan() {
+        for _, pick := range []func(string, []*yyalt) int{
+            nil,
+            func(name string, alts []*yyalt) int { return len(alts) - 1 },
+            func(name string, alts []*yyalt) int { return len(alts) },
+        } {
+            yyambiguity = pick
+            result, err := yyparserSpans(yylexer(lines.Text()))
+            if err != nil {
+                fmt.Println(err)
+            } else {
+                fmt.Println(result.v)
+            }
+        }
+    }
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := runGenerated(t, gobin, (&Generator{}).GLRParser, grammar, "a + b * c\na + b + c\na\n")
+	expected := `(a + (b * c))
+((a + b) * c)
+(a + (b * c))
+((a + b) + c)
+(a + (b + c))
+((a + b) + c)
+a
+a
+a
+`
+	if output != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, output)
+	}
+}
+
+// generateLR is generate for LRParser.
+func generateLR(t *testing.T, generator *Generator, grammar string) ([]byte, error) {
+	return generateWith(t, generator.LRParser, grammar)
+}
+
+// evalLR parses the space separated words of input with an LR table of
+// grammar and runs the actions with evalAction.
+func evalLR(t *testing.T, grammar *Grammar, table *LRTable, input string) string {
+	value, err := runLR(grammar, table, 0, input)
+	if err != nil {
+		t.Fatalf("Parsing %s: %v", input, err)
+	}
+	return value
+}
+
+// runLR is evalLR from the state of another start symbol, returning syntax
+// errors.
+func runLR(grammar *Grammar, table *LRTable, start int, input string) (string, error) {
+	symbols := grammar.MergeSymbols()
+	words := append(strings.Fields(input), "$")
+	states := []int{start}
+	values := make([]string, 0)
+	for {
+		act := table.Action[states[len(states)-1]][symbols[words[0]]]
+		switch {
+		case act <= lrReduce(table.Accept):
+			return values[0], nil
+		case act > 0:
+			states = append(states, act-1)
+			values = append(values, words[0])
+			words = words[1:]
+		case act < 0:
+			prod := &grammar.prods[-act-1]
+			states = states[:len(states)-len(prod.body)]
+			values = evalAction(grammar, prod, values)
+			next := table.Goto[states[len(states)-1]][symbols[prod.name]-grammar.maxToken-1]
+			states = append(states, next)
+		default:
+			return "", fmt.Errorf("unexpected %s", words[0])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// LRParser reads a grammar from in and writes a shift/reduce parser driven
+// by the table LRMethod builds to out. Errors are reported as by LLParser.
+// With the PreferFirst policy conflicts are resolved as yacc does. The
+// parser stops at the first syntax error and returns it alone as
+// SyntaxErrors.
+func (self *Generator) LRParser(in *os.File, out *os.File) error {
+	grammar, scanner, err := self.loadGrammar(in)
+	if err != nil {
+		return err
+	}
+
+	mergedSymbols := grammar.MergeSymbols()
+	if self.StateReport != nil {
+		for _, stats := range CompareTables(grammar.prods, mergedSymbols, grammar.precs, grammar.maxToken, grammar.startSymbols()...) {
+			if _, err := fmt.Fprintln(self.StateReport, stats); err != nil {
+				return err
+			}
+		}
+	}
+	table, conflicts := ComputeLRTable(self.LRMethod, grammar.prods, mergedSymbols, grammar.precs, grammar.maxToken, grammar.startSymbols()...)
+	if len(conflicts) > 0 {
+		if self.ConflictPolicy == FailOnConflict {
+			return &ConflictError{Conflicts: conflicts, Table: self.LRMethod.String()}
+		}
+		for _, conflict := range conflicts {
+			parserLog("Resolved as yacc does: %s", conflict)
+		}
+	}
+
+	return writeParser(scanner, in, out, func(buf *bytes.Buffer, srcName, outName string) {
+		grammar.printLRFile(fmt.Sprintf("A %s Grammar Parser", self.LRMethod), table, mergedSymbols, srcName, outName, buf)
+	})
+}
+
+func (self *Grammar) printLRFile(title string,
+	table *LRTable,
+	tokens map[string]int,
+	srcName, outName string,
+	out *bytes.Buffer) {
+	self.printPrologue(title, out)
+	self.printLRTables(table, tokens, out)
+	self.printCharmap(tokens, out)
+	self.printRuncode(srcName, outName, out)
+
+	self.printLREntryPoints(out)
+
+	out.WriteString(`// yyparse parses from state start the words nextWord returns.
+func yyparse(start int, nextWord func()(bool, string, *yytype, Span)) (*yytype, error) {
+    values := NewStack[*yytype]()
+    spans := NewStack[Span]()
+    states := NewStack[int]()
+    states.push(start)
+
+    // the words the parser could take, for syntax errors: the reductions
+    // they lead to are tried on a copy of the states, as the lookaheads of
+    // merged states let a reduction happen on words that fail after it
+    expects := func(idx int) bool {
+        tried := *states
+        for {
+            act := yyaction[tried.peek(0)][idx]
+            switch {
+            case act == 0:
+                return false
+            case act > 0 || act <= yyaccept:
+                return true
+            }
+            prod := -act - 1
+            for i := 0; i < yylen[prod]; i++ {
+                tried.pop()
+            }
+            tried.push(yygoto[tried.peek(0)][yylhs[prod]-MAXTOKEN-1])
+        }
+    }
+    pos := 0
+    eof, word, yyval, span := nextWord()
+    if eof {
+        word = "$"
+    }
+    wordIdx := word2Idx(word)
+    for wordIdx >= 0 {
+        act := yyaction[states.peek(0)][wordIdx]
+        switch {
+        case act <= yyaccept:
+            return values.pop(), nil
+        case act > 0:
+            states.push(act - 1)
+            values.push(yyval)
+            spans.push(span)
+            pos++
+            eof, word, yyval, span = nextWord()
+            if eof {
+                word = "$"
+            }
+            wordIdx = word2Idx(word)
+        case act < 0:
+            prod := -act - 1
+            for i := 0; i < yylen[prod]; i++ {
+                states.pop()
+            }
+            yyruncode(prod, values, spans)
+            states.push(yygoto[states.peek(0)][yylhs[prod]-MAXTOKEN-1])
+        default:
+            return nil, SyntaxErrors{yysyntaxError(pos, span, word, wordIdx, expects)}
+        }
+    }
+    return nil, SyntaxErrors{yysyntaxError(pos, span, word, wordIdx, expects)}
+}
+
+`)
+}
+
+// printLREntryPoints writes the entry points of an LR parser, which parses
+// the i-th start symbol from state i.
+func (self *Grammar) printLREntryPoints(out *bytes.Buffer) {
+	starts := make([]int, len(self.startSymbols()))
+	for i := range starts {
+		starts[i] = i
+	}
+	self.printEntryPoints(starts, out)
+}
+
+// printLRTables writes the action and goto tables, and what a reduction of
+// every production pops and pushes.
+func (self *Grammar) printLRTables(table *LRTable, tokens map[string]int, out *bytes.Buffer) {
+	// yyaction[state][word] is 0 on errors, state+1 to shift, -prod-1 to
+	// reduce, yyaccept or below to accept; yygoto[state][nonterminal-MAXTOKEN-1]
+	// the state after a reduction
+	out.WriteString(fmt.Sprintf("const yyaccept = %d\n\n", lrReduce(table.Accept)))
+	out.WriteString("var yyaction = [][]int{\n")
+	for _, row := range table.Action {
+		out.WriteString(fmt.Sprintf("\t%s,\n", intSlice(row)))
+	}
+	out.WriteString("}\n\n")
+	out.WriteString("var yygoto = [][]int{\n")
+	for _, row := range table.Goto {
+		out.WriteString(fmt.Sprintf("\t%s,\n", intSlice(row)))
+	}
+	out.WriteString("}\n\n")
+
+	// what a reduction of every production pops and pushes
+	lhs := make([]int, len(self.prods))
+	lens := make([]int, len(self.prods))
+	for i, prod := range self.prods {
+		lhs[i] = tokens[prod.name]
+		lens[i] = len(prod.body)
+	}
+	out.WriteString(fmt.Sprintf("var yylhs = %s\n\n", intSlice(lhs)))
+	out.WriteString(fmt.Sprintf("var yylen = %s\n\n", intSlice(lens)))
+}
+
+// intSlice writes ints as a Go slice literal.
+func intSlice(values []int) string {
+	text := "[]int{"
+	for i, v := range values {
+		if i > 0 {
+			text += ", "
+		}
+		text += fmt.Sprint(v)
+	}
+	return text + "}"
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// lineFileName names the grammar file for //line directives in the generated
+// file, which resolve relative names against the generated file's directory.
+func lineFileName(src, out string) string {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return src
+	}
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return src
+	}
+	rel, err := filepath.Rel(filepath.Dir(absOut), absSrc)
+	if err != nil {
+		return src
+	}
+	return rel
+}
+
+func writeLineDirective(out *bytes.Buffer, file string, line int) {
+	out.WriteString(fmt.Sprintf("//line %s:%d\n", file, line))
+}
+
+// formatOutput gofmts the generated file named outName. gofmt may split an
+// action copied from the grammar over several lines, so instead of keeping
+// the //line directives as written they are recomputed: every line starting
+// a statement, declaration or expression points where that node came from.
+func formatOutput(src []byte, outName string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, outName, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("generated parser is not valid Go: %s", err.Error())
+	}
+	origins := make([]token.Position, 0)
+	walkNodes(file, func(node ast.Node) {
+		origins = append(origins, fset.Position(node.Pos()))
+	})
+
+	formatted, err := format.Source(stripLineDirectives(src))
+	if err != nil {
+		return nil, fmt.Errorf("generated parser is not valid Go: %s", err.Error())
+	}
+	fset = token.NewFileSet()
+	file, err = parser.ParseFile(fset, outName, formatted, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("generated parser is not valid Go: %s", err.Error())
+	}
+	// origin of the first node starting on each formatted line
+	wanted := make(map[int]token.Position)
+	idx := 0
+	walkNodes(file, func(node ast.Node) {
+		line := fset.Position(node.Pos()).Line
+		if _, b := wanted[line]; !b {
+			wanted[line] = origins[idx]
+		}
+		idx++
+	})
+
+	var out bytes.Buffer
+	// the position the compiler gives to the next written line
+	curFile, curLine := outName, 1
+	written := 0
+	lines := strings.Split(string(formatted), "\n")
+	for i, text := range lines {
+		origin, b := wanted[i+1]
+		if b && origin.Filename == outName {
+			origin.Line = written + 1
+		}
+		if b && (curFile != origin.Filename || curLine != origin.Line) {
+			// gofmt keeps directives in a doc comment apart from its text
+			if i > 0 && isDocText(lines[i-1]) {
+				out.WriteString("//\n")
+				written++
+				if origin.Filename == outName {
+					origin.Line++
+				}
+			}
+			if origin.Filename == outName {
+				origin.Line++
+			}
+			writeLineDirective(&out, origin.Filename, origin.Line)
+			written++
+			curFile, curLine = origin.Filename, origin.Line
+		}
+		out.WriteString(text)
+		if i != len(lines)-1 {
+			out.WriteString("\n")
+		}
+		written++
+		curLine++
+	}
+	return out.Bytes(), nil
+}
+
+// isDocText tells whether line is text of a top-level comment.
+func isDocText(line string) bool {
+	return strings.HasPrefix(line, "//") && line != "//" && !strings.HasPrefix(line, "//line ")
+}
+
+// walkNodes visits the syntax nodes of file in a fixed order, leaving out
+// comments so that files differing only in comments visit the same nodes.
+func walkNodes(file *ast.File, visit func(ast.Node)) {
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node.(type) {
+		case nil, *ast.CommentGroup, *ast.Comment:
+			return false
+		}
+		visit(node)
+		return true
+	})
+}
+
+func stripLineDirectives(src []byte) []byte {
+	lines := strings.Split(string(src), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "//line ") {
+			kept = append(kept, line)
+		}
+	}
+	return []byte(strings.Join(kept, "\n"))
+}
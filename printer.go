@@ -0,0 +1,392 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// loadGrammar reads the grammar of in and applies the rewrites the
+// Generator asks for.
+func (self *Generator) loadGrammar(in *os.File) (*Grammar, *Scanner, error) {
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanner := &Scanner{name: in.Name(), content: content, index: 0}
+	grammar := NewGrammar()
+
+	if err := grammar.ParseHeaders(scanner); err != nil {
+		return nil, nil, err
+	}
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		return nil, nil, err
+	}
+	if len(grammar.prods) == 0 {
+		return nil, nil, scanner.errorf(scanner.index, "grammar has no rules")
+	}
+	if err := grammar.checkActions(); err != nil {
+		return nil, nil, err
+	}
+	if grammar.typed = self.TypedValues; grammar.typed {
+		if err := grammar.checkTypes(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := grammar.buildLexer(); err != nil {
+		return nil, nil, err
+	}
+	if self.EliminateLeftRecursion {
+		if err := grammar.EliminateLeftRecursion(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if self.LeftFactor {
+		grammar.LeftFactor()
+	}
+	if self.GrammarDump != nil {
+		if err := grammar.DumpGrammar(self.GrammarDump); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return grammar, scanner, nil
+}
+
+// writeParser formats the parser print writes, followed by the user code
+// after the rules, into out.
+func writeParser(scanner *Scanner, in, out *os.File, print func(buf *bytes.Buffer, srcName, outName string)) error {
+	restCode := scanner.Reminder()
+	restPos := scanner.position(scanner.index)
+
+	// actions and the user code point back to the grammar file
+	srcName := lineFileName(in.Name(), out.Name())
+	outName := filepath.Base(out.Name())
+	var buf bytes.Buffer
+	print(&buf, srcName, outName)
+	if len(restCode) > 0 {
+		writeLineDirective(&buf, srcName, restPos.Line)
+		buf.Write(restCode)
+	}
+	formatted, err := formatOutput(buf.Bytes(), outName)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(formatted)
+	return err
+}
+
+// printPrologue writes what every generated parser starts with: the package
+// clause, the value type, the value stack and the word lookup.
+func (self *Grammar) printPrologue(title string, out *bytes.Buffer) {
+	out.WriteString(fmt.Sprintf("// %s, writen by Zach41\n// Version 0.1\n\n", title))
+
+	// package name
+	out.WriteString(fmt.Sprintf("package %s\n\n", self.packagename))
+	// modules
+	out.WriteString("import (\n")
+	out.WriteString("\t\"fmt\"\n")
+	for _, module := range self.modules {
+		if module == "fmt" {
+			continue
+		}
+		out.WriteString(fmt.Sprintf("\t\"%s\"\n", module))
+	}
+	out.WriteString(")\n\n")
+
+	out.WriteString("const (\n")
+	out.WriteString(fmt.Sprintf("\tMAXTOKEN = %d\n", self.maxToken))
+	out.WriteString(fmt.Sprintf("\tMINTOKEN = %d\n", self.minToken))
+	out.WriteString(")\n\n")
+	// write yytype
+	out.WriteString("type yytype struct {\n")
+	for _, tname := range sortedKeys(self.unionTypes) {
+		out.WriteString(fmt.Sprintf("\t%s    %s\n", tname, self.unionTypes[tname]))
+	}
+	out.WriteString("}\n\n")
+
+	// Stack is a helper struct
+	out.WriteString(`type Stack[T any] struct {
+    values [2048]T    // stack size is limited to 2048
+    top    int
+}
+
+func (stack *Stack[T]) pop() T {
+    var zero T
+    if stack.top < 0 {
+        return zero
+    }
+    ret := stack.values[stack.top]
+    stack.top -= 1
+    return ret
+}
+
+// peek returns the value depth entries below the top
+func (stack *Stack[T]) peek(depth int) T {
+    var zero T
+    if stack.top-depth < 0 {
+        return zero
+    }
+    return stack.values[stack.top-depth]
+}
+
+func (stack *Stack[T]) push(value T) {
+    if stack.top >= 2047 {
+        return
+    } else {
+        stack.top += 1
+        stack.values[stack.top] = value
+    }
+}
+
+func (stack *Stack[T]) empty() bool {
+    return stack.top < 0
+}
+
+func NewStack[T any]() *Stack[T] {
+    stack := &Stack[T]{top: -1}
+    return stack
+}
+
+// word2Idx returns the id of the token word, or else of the literal 'word',
+// and -1 if it is neither, as for a word spelled like a nonterminal.
+func word2Idx(word string) int {
+    if idx, b := yycharmap[word]; b && idx >= 1 && idx <= MAXTOKEN {
+        return idx
+    }
+    if idx, b := yycharmap[fmt.Sprintf("'%s'", word)]; b && idx >= 1 && idx <= MAXTOKEN {
+        return idx
+    }
+    return -1
+}
+
+// Position is where a word starts or ends in the text parsed. Line and
+// Column start at 1 and are 0 when the words come without them; Offset
+// counts bytes, or words then.
+type Position struct {
+    Offset int
+    Line   int
+    Column int
+}
+
+// Span is the text from Start up to End a word or a symbol was read from.
+type Span struct {
+    Start, End Position
+}
+
+// SyntaxError is returned when Got, the word at Pos counting from 0, cannot
+// follow the words before it. Expected are the words that could, Span is
+// where Got is.
+type SyntaxError struct {
+    Pos      int
+    Got      string
+    Expected []string
+    Span     Span
+}
+
+func (err *SyntaxError) Error() string {
+    at := ""
+    if err.Span.Start.Line > 0 {
+        at = fmt.Sprintf("%d:%d: ", err.Span.Start.Line, err.Span.Start.Column)
+    }
+    if len(err.Expected) == 0 {
+        return fmt.Sprintf("%sunexpected %s", at, err.Got)
+    }
+    expected := err.Expected[0]
+    for i := 1; i < len(err.Expected); i++ {
+        if i == len(err.Expected)-1 {
+            expected += " or " + err.Expected[i]
+        } else {
+            expected += ", " + err.Expected[i]
+        }
+    }
+    return fmt.Sprintf("%sexpected %s but got %s", at, expected, err.Got)
+}
+
+// SyntaxErrors are the syntax errors a parse found, in order. Parsers that
+// stop at the first one return it alone.
+type SyntaxErrors []*SyntaxError
+
+func (errs SyntaxErrors) Error() string {
+    text := errs[0].Error()
+    for _, err := range errs[1:] {
+        text += "; " + err.Error()
+    }
+    return text
+}
+
+// yysyntaxError is the error for word, whose id is wordIdx, at pos and span;
+// expects tells the words the parser could take there.
+func yysyntaxError(pos int, span Span, word string, wordIdx int, expects func(idx int) bool) *SyntaxError {
+    name := func(idx int) string {
+        if idx == 1 {
+            return "end of input"
+        }
+        return yynames[idx]
+    }
+    err := &SyntaxError{Pos: pos, Got: fmt.Sprintf("%q", word), Span: span}
+    if wordIdx >= 0 {
+        err.Got = name(wordIdx)
+    }
+    for idx := 1; idx <= MAXTOKEN; idx++ {
+        if expects(idx) && yynames[idx] != "error" {
+            err.Expected = append(err.Expected, name(idx))
+        }
+    }
+    return err
+}
+
+// yylocate returns the span of the top n symbols of spans, empty at the end
+// of the symbol below if n is 0.
+func yylocate(spans *Stack[Span], n int) Span {
+    if n == 0 {
+        end := spans.peek(0).End
+        return Span{end, end}
+    }
+    return Span{spans.peek(n - 1).Start, spans.peek(0).End}
+}
+
+// yywordSpans gives the words nextWord returns the span of their number:
+// the i-th word spans the offsets i to i+1.
+func yywordSpans(nextWord func() (bool, string, *yytype)) func() (bool, string, *yytype, Span) {
+    pos := 0
+    return func() (bool, string, *yytype, Span) {
+        eof, word, yyval := nextWord()
+        span := Span{Position{Offset: pos}, Position{Offset: pos + 1}}
+        pos++
+        return eof, word, yyval, span
+    }
+}
+
+`)
+}
+
+// printCharmap writes yycharmap, the id of every symbol, and yynames, the
+// symbol of every id.
+func (self *Grammar) printCharmap(tokens map[string]int, out *bytes.Buffer) {
+	// write all symbol mappings
+	out.WriteString("var yycharmap = map[string]int{\n")
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return tokens[names[i]] < tokens[names[j]] })
+	for _, name := range names {
+		out.WriteString(fmt.Sprintf("\t%q: %d,\n", name, tokens[name]))
+	}
+	out.WriteString("}\n\n")
+
+	out.WriteString("var yynames = []string{\n")
+	for _, name := range names {
+		out.WriteString(fmt.Sprintf("\t%q,\n", name))
+	}
+	out.WriteString("}\n\n")
+}
+
+// printEntryPoints writes yyparser, which parses the first start symbol, and
+// yyparseX for every symbol X named by %start. They call yyparse with the
+// matching entry of starts and return its value or SyntaxErrors. Each
+// has a Spans variant taking words with their spans. A grammar with
+// patterns gets yylexer to feed them too.
+func (self *Grammar) printEntryPoints(starts []int, out *bytes.Buffer) {
+	// yyparser is the entry point named after no symbol
+	names, ids := []string{"yyparser"}, []int{starts[0]}
+	for i, start := range self.starts {
+		names = append(names, "yyparse"+start)
+		ids = append(ids, starts[i])
+	}
+	for i, name := range names {
+		out.WriteString(fmt.Sprintf("func %s(nextWord func() (bool, string, *yytype)) (*yytype, error) {\n", name))
+		out.WriteString(fmt.Sprintf("\treturn yyparse(%d, yywordSpans(nextWord))\n}\n\n", ids[i]))
+		out.WriteString(fmt.Sprintf("// %sSpans is %s for words that come with their spans.\n", name, name))
+		out.WriteString(fmt.Sprintf("func %sSpans(nextWord func() (bool, string, *yytype, Span)) (*yytype, error) {\n", name))
+		out.WriteString(fmt.Sprintf("\treturn yyparse(%d, nextWord)\n}\n\n", ids[i]))
+	}
+	if self.lexer != nil {
+		self.printLexer(out)
+	}
+}
+
+// printRuncode writes yyruncode, which runs the action of a production on
+// the values of its body.
+func (self *Grammar) printRuncode(srcName, outName string, out *bytes.Buffer) {
+	// running code when reduction happends
+	// idx: which production is reducing, start with 0
+	// values: current values stack
+	// spans: current spans stack, parallel to values
+	// return a yytype value
+	out.WriteString("func yyruncode(idx int, values *Stack[*yytype], spans *Stack[Span]) *yytype {\n")
+	out.WriteString("\tlhs := &yytype{}\n")
+	out.WriteString("\tswitch idx {\n")
+	for i, prod := range self.prods {
+		var codeStr string
+		var codePos Position
+		if len(prod.code) == 0 {
+			codeStr, codePos = self.defaultcode, self.defaultPos
+		} else {
+			codeStr, codePos = prod.code, prod.codePos
+		}
+		parserLog("Original Code:\n%s", codeStr)
+		out.WriteString(fmt.Sprintf("\tcase %d:\n", i))
+		// the spans of the body stay on their stack until the action is
+		// done, and are replaced by the one of the rule then
+		out.WriteString(fmt.Sprintf("\t\tyyloc := yylocate(spans, %d)\n", len(prod.body)+prod.spanBelow))
+		// every symbol of the body has a value on the stack, the last one
+		// on top
+		used, setsLhs := make(map[int]bool), false
+		for _, ref := range findActionRefs(codeStr) {
+			if ref.loc {
+				continue
+			}
+			if ref.lhs {
+				setsLhs = true
+			} else {
+				used[ref.index] = true
+			}
+		}
+		for rhsIdx := len(prod.body); rhsIdx >= 1; rhsIdx-- {
+			switch {
+			case used[rhsIdx] && self.typed:
+				out.WriteString(fmt.Sprintf("\t\trhs_%d := values.pop().%s\n", rhsIdx, self.symbolType(prod.body[rhsIdx-1])))
+			case used[rhsIdx]:
+				out.WriteString(fmt.Sprintf("\t\trhs_%d := values.pop()\n", rhsIdx))
+			default:
+				out.WriteString("\t\tvalues.pop()\n")
+			}
+		}
+		field := self.nontermTypes[prod.name]
+		typedLhs := self.typed && setsLhs && len(field) > 0
+		if typedLhs {
+			out.WriteString(fmt.Sprintf("\t\tvar lhs %s\n", self.unionTypes[field]))
+		}
+		prodCode := rewriteActionRefs(codeStr, func(ref actionRef) string {
+			return self.actionValue(&prod, ref)
+		})
+		if codePos.Line > 0 {
+			writeLineDirective(out, srcName, codePos.Line)
+			out.WriteString(fmt.Sprintf("\t\t%s\n", prodCode))
+			// formatOutput renumbers the generated lines
+			writeLineDirective(out, outName, 1)
+		} else {
+			out.WriteString(fmt.Sprintf("\t\t%s\n", prodCode))
+		}
+		if len(prod.body) > 0 {
+			out.WriteString(fmt.Sprintf("\t\tspans.top -= %d\n", len(prod.body)))
+		}
+		out.WriteString("\t\tspans.push(yyloc)\n")
+		switch {
+		case typedLhs:
+			out.WriteString(fmt.Sprintf("\t\tyyval := &yytype{%s: lhs}\n\t\tvalues.push(yyval)\n\t\treturn yyval\n", field))
+		case self.typed:
+			out.WriteString("\t\tvalues.push(&yytype{})\n\t\treturn values.peek(0)\n")
+		default:
+			out.WriteString("\t\tvalues.push(lhs)\n\t\treturn lhs\n")
+		}
+		// }
+	}
+	out.WriteString("\t}\n\t return lhs\n}\n\n")
+}
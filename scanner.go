@@ -2,6 +2,10 @@ package parser
 
 import (
 	"errors"
+	"fmt"
+	goscanner "go/scanner"
+	"go/token"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -19,18 +23,47 @@ const (
 	hfield
 	separate
 	other
+	lparen
+	rparen
+	repeat  // ?, * or +
+	pattern // /regexp/ after a %token symbol or %skip
 )
 
 type TokType int
 
 type Scanner struct {
+	name    string // file name used in positions
 	content []byte
 	index   int
+
+	// position() cache: line number and line start of offset `seen`
+	seen      int
+	line      int
+	lineStart int
+}
+
+var errEOF = errors.New("End of File")
+
+// Position is a location in the grammar file. Line and Column start at 1,
+// Column counts bytes.
+type Position struct {
+	File   string
+	Offset int
+	Line   int
+	Column int
+}
+
+func (pos Position) String() string {
+	if len(pos.File) == 0 {
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
 }
 
 type WordTok struct {
 	tokType TokType
 	text    string
+	pos     Position
 }
 
 func (self *Scanner) Reminder() []byte {
@@ -38,16 +71,15 @@ func (self *Scanner) Reminder() []byte {
 }
 
 func (self *Scanner) NextWord() (err error, word WordTok) {
-	if self.index >= len(self.content) {
-		err = errors.New("End of File")
-		return
-	}
-
 Omitspace: // omit spaces
 	for {
+		if self.index >= len(self.content) {
+			err = errEOF
+			return
+		}
 		r, l := utf8.DecodeRune(self.content[self.index:])
-		if r == utf8.RuneError {
-			err = errors.New("Invalid utf8 encoding")
+		if r == utf8.RuneError && l == 1 {
+			err = self.errorf(self.index, "invalid utf8 encoding")
 			return
 		}
 		if !unicode.IsSpace(r) || r == '\n' {
@@ -63,9 +95,13 @@ Omitspace: // omit spaces
 		// comments
 		for {
 			self.index += l
+			if self.index >= len(self.content) {
+				err = errEOF
+				return
+			}
 			r, l = utf8.DecodeRune(self.content[self.index:])
-			if r == utf8.RuneError {
-				err = errors.New("Invalid utf8 encoding")
+			if r == utf8.RuneError && l == 1 {
+				err = self.errorf(self.index, "invalid utf8 encoding")
 				return
 			}
 			if r == '\n' {
@@ -74,7 +110,9 @@ Omitspace: // omit spaces
 		}
 	}
 
-	start, inchar, incode, tokType := self.index, false, 0, other
+	start, inchar, tokType := self.index, false, other
+	// the end of the quoted text of a literal, before any [alias]
+	quoted := start
 
 Loop:
 	for {
@@ -83,12 +121,21 @@ Loop:
 		}
 
 		r, l := utf8.DecodeRune(self.content[self.index:])
-		if r == utf8.RuneError {
-			err = errors.New("Invalid utf8 encoding")
+		if r == utf8.RuneError && l == 1 {
+			err = self.errorf(self.index, "invalid utf8 encoding")
 			return
 		}
+		if inchar && r == '\\' {
+			// an escaped rune does not end the literal
+			_, n := utf8.DecodeRune(self.content[self.index+l:])
+			self.index += l + n
+			continue
+		}
 		if r == '\'' {
 			inchar = !inchar
+			if !inchar && quoted == start {
+				quoted = self.index + l
+			}
 		}
 		if self.index == start {
 			switch r {
@@ -105,12 +152,35 @@ Loop:
 				self.index++
 				break Loop
 			case '{':
-				incode++
 				tokType = code
+				self.index, err = self.scanCode(start)
+				if err != nil {
+					return
+				}
+				break Loop
 			case '|':
 				tokType = alternate
 				self.index++
 				break Loop
+			case '(':
+				tokType = lparen
+				self.index++
+				break Loop
+			case ')':
+				tokType = rparen
+				self.index++
+				break Loop
+			case '?', '*', '+':
+				tokType = repeat
+				self.index++
+				break Loop
+			case '/':
+				tokType = pattern
+				self.index, err = self.scanPattern(start)
+				if err != nil {
+					return
+				}
+				break Loop
 			case '%':
 				tokType = hfield
 			case '\'':
@@ -122,20 +192,125 @@ Loop:
 					tokType = term
 				}
 			}
-		} else if incode > 0 && r == '{' {
-			incode++
-		} else if incode > 0 && r == '}' {
-			incode--
 		}
-		if incode == 0 && !inchar && unicode.IsSpace(r) {
+		if !inchar && unicode.IsSpace(r) {
+			break
+		}
+		// EBNF operators may follow a symbol right away
+		if self.index > start && !inchar && (tokType == term || tokType == nonterm || tokType == literal) &&
+			strings.ContainsRune("()?*+", r) {
 			break
 		}
 		self.index += l
 	}
 	word.tokType = TokType(tokType)
 	word.text = string(self.content[start:self.index])
+	word.pos = self.position(start)
 	if word.text == "%%" {
 		word.tokType = TokType(separate)
 	}
+	if word.tokType == literal {
+		if _, e := unquoteLiteral(string(self.content[start:quoted])); e != nil {
+			err = self.errorf(start, "invalid literal %s", word.text)
+		}
+	}
 	return
 }
+
+// peekWord returns the next word without consuming it.
+func (self *Scanner) peekWord() (error, WordTok) {
+	index := self.index
+	err, word := self.NextWord()
+	self.index = index
+	return err, word
+}
+
+// position converts a byte offset of the content into a Position. Offsets
+// are usually asked for in increasing order, so the line count is cached.
+func (self *Scanner) position(offset int) Position {
+	if offset < self.seen {
+		self.seen, self.line, self.lineStart = 0, 0, 0
+	}
+	for ; self.seen < offset; self.seen++ {
+		if self.content[self.seen] == '\n' {
+			self.line++
+			self.lineStart = self.seen + 1
+		}
+	}
+	return Position{
+		File:   self.name,
+		Offset: offset,
+		Line:   self.line + 1,
+		Column: offset - self.lineStart + 1,
+	}
+}
+
+// errorf builds a GrammarError for the given byte offset of the content.
+func (self *Scanner) errorf(offset int, format string, v ...interface{}) error {
+	return &GrammarError{Position: self.position(offset), Msg: fmt.Sprintf(format, v...)}
+}
+
+// scanCode returns the end of the code block opening at start. The block is
+// lexed as Go, so braces in strings, runes and comments do not count.
+func (self *Scanner) scanCode(start int) (int, error) {
+	src := self.content[start:]
+	file := token.NewFileSet().AddFile(self.name, -1, len(src))
+	unterminated := -1
+	msg := ""
+	var lexer goscanner.Scanner
+	lexer.Init(file, src, func(pos token.Position, m string) {
+		if strings.HasSuffix(m, "not terminated") {
+			unterminated, msg = pos.Offset, m
+		}
+	}, goscanner.ScanComments)
+	depth := 0
+	for {
+		pos, tok, _ := lexer.Scan()
+		switch tok {
+		case token.EOF:
+			if unterminated >= 0 {
+				return 0, self.errorf(start+unterminated, "%s in code block", msg)
+			}
+			return 0, self.errorf(start, "unterminated code block")
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth--; depth == 0 {
+				return start + file.Offset(pos) + 1, nil
+			}
+		}
+	}
+}
+
+// wordError reports an error at the start of word.
+func (self *Scanner) wordError(word WordTok, format string, v ...interface{}) error {
+	return &GrammarError{Position: word.pos, Msg: fmt.Sprintf(format, v...)}
+}
+
+// scanPattern returns the end of the /regexp/ opening at start. A '/' ends
+// it unless escaped or in a [class]; a pattern may not span lines.
+func (self *Scanner) scanPattern(start int) (int, error) {
+	inclass := false
+	for i := start + 1; i < len(self.content); i++ {
+		switch c := self.content[i]; {
+		case c == '\\' && i+1 < len(self.content) && self.content[i+1] != '\n':
+			i++
+		case c == '\n':
+			return 0, self.errorf(start, "unterminated pattern")
+		case c == '[' && !inclass:
+			inclass = true
+			// a ']' first in the class stands for itself
+			if i+1 < len(self.content) && self.content[i+1] == '^' {
+				i++
+			}
+			if i+1 < len(self.content) && self.content[i+1] == ']' {
+				i++
+			}
+		case c == ']':
+			inclass = false
+		case c == '/' && !inclass:
+			return i + 1, nil
+		}
+	}
+	return 0, self.errorf(start, "unterminated pattern")
+}
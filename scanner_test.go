@@ -85,3 +85,100 @@ Add : floating
 		t.Errorf("Expetected End of File")
 	}
 }
+
+func TestScannerPositions(t *testing.T) {
+	content := "%token<ival> integer\n\n%%\nNum : integer { $$ = $1 }\n    | '-' integer\n    ;"
+	scanner := Scanner{name: "test.y", content: []byte(content), index: 0}
+	expected := []struct {
+		text string
+		pos  Position
+	}{
+		{"%token<ival>", Position{"test.y", 0, 1, 1}},
+		{"integer", Position{"test.y", 13, 1, 14}},
+		{"\n", Position{"test.y", 20, 1, 21}},
+		{"\n", Position{"test.y", 21, 2, 1}},
+		{"%%", Position{"test.y", 22, 3, 1}},
+		{"\n", Position{"test.y", 24, 3, 3}},
+		{"Num", Position{"test.y", 25, 4, 1}},
+		{":", Position{"test.y", 29, 4, 5}},
+		{"integer", Position{"test.y", 31, 4, 7}},
+		{"{ $$ = $1 }", Position{"test.y", 39, 4, 15}},
+		{"\n", Position{"test.y", 50, 4, 26}},
+		{"|", Position{"test.y", 55, 5, 5}},
+		{"'-'", Position{"test.y", 57, 5, 7}},
+	}
+	for _, e := range expected {
+		err, word := scanner.NextWord()
+		if err != nil || word.text != e.text || word.pos != e.pos {
+			t.Errorf("Expected %q at %v, got %q at %v", e.text, e.pos, word.text, word.pos)
+		}
+	}
+}
+
+func TestScannerCode(t *testing.T) {
+	blocks := []string{
+		"{ fmt.Println(\"}\") }",
+		"{ s := `{\n}}` }",
+		"{ r := '}' /* } */ }",
+		"{ // }\n}",
+		"{ if $1 { $$ = T{$2} } }",
+	}
+	for _, block := range blocks {
+		scanner := Scanner{content: []byte(block + " Add\n"), index: 0}
+		checkWord(&scanner, t, code, block)
+		checkWord(&scanner, t, nonterm, "Add")
+	}
+
+	for content, expected := range map[string]string{
+		"{ s := \"}\n":  "test.y:1:8: string literal not terminated in code block",
+		"{ s := `}\n":   "test.y:1:8: raw string literal not terminated in code block",
+		"{ /* } */\n":   "test.y:1:1: unterminated code block",
+		"{ x /* }\n  }": "test.y:1:5: comment not terminated in code block",
+	} {
+		scanner := Scanner{name: "test.y", content: []byte(content), index: 0}
+		if err, _ := scanner.NextWord(); err == nil || err.Error() != expected {
+			t.Errorf("Expected %s for %q, got %v", expected, content, err)
+		}
+	}
+}
+
+func TestScannerPattern(t *testing.T) {
+	patterns := []string{
+		`/[0-9]+/`,
+		`/[/]+/`,
+		`/[]/]/`,
+		`/[^]/]/`,
+		`/a\/b/`,
+		`/#[^\n]*/`,
+	}
+	for _, text := range patterns {
+		scanner := Scanner{content: []byte("integer " + text + " float\n"), index: 0}
+		checkWord(&scanner, t, term, "integer")
+		checkWord(&scanner, t, pattern, text)
+		checkWord(&scanner, t, term, "float")
+	}
+
+	for _, content := range []string{"/[0-9]+\n/", "/a\\/", "/[/]"} {
+		scanner := Scanner{name: "test.y", content: []byte(content), index: 0}
+		if err, _ := scanner.NextWord(); err == nil || err.Error() != "test.y:1:1: unterminated pattern" {
+			t.Errorf("Expected an unterminated pattern for %q, got %v", content, err)
+		}
+	}
+}
+
+func TestScannerLiteral(t *testing.T) {
+	literals := []string{`'+'`, `'\''`, `'\\'`, `'a\'b'`, `'\t'[tab]`, `'\u00e9'`}
+	for _, text := range literals {
+		scanner := Scanner{content: []byte("integer " + text + " float\n"), index: 0}
+		checkWord(&scanner, t, term, "integer")
+		checkWord(&scanner, t, literal, text)
+		checkWord(&scanner, t, term, "float")
+	}
+
+	for _, content := range []string{`'\q'`, `'\'`, `'abc`} {
+		scanner := Scanner{name: "test.y", content: []byte(content), index: 0}
+		if err, _ := scanner.NextWord(); err == nil || err.Error() != "test.y:1:1: invalid literal "+content {
+			t.Errorf("Expected an invalid literal for %q, got %v", content, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+%package main
+
+%import bufio os strings
+
+%union {
+    s string
+}
+
+%token<s> id /[a-z]+/
+%skip /[ \n]+/ /#[^\n]*/
+%type<s> E T
+
+%%
+
+# every expression is printed with the span of its text
+E : E[left] '+' T { $$ = fmt.Sprintf("(%s + %s)@%s", $left, $T, span(@$)) }
+  | T             { $$ = $1 }
+  ;
+
+T : id { $$ = fmt.Sprintf("%s@%s", $1, span(@id)) }
+  ;
+
+%%
+
+func span(s Span) string {
+    return fmt.Sprintf("%d:%d-%d:%d", s.Start.Line, s.Start.Column, s.End.Line, s.End.Column)
+}
+
+// every line of the standard input is parsed, a \n in it standing for a
+// line break
+func main() {
+    lines := bufio.NewScanner(os.Stdin)
+    for lines.Scan() {
+        text := strings.ReplaceAll(lines.Text(), `\n`, "\n")
+        if result, err := yyparserSpans(yylexer(text)); err == nil {
+            fmt.Println(result.s)
+        } else {
+            fmt.Println(err)
+        }
+    }
+}
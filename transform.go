@@ -0,0 +1,431 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EliminateLeftRecursion rewrites direct and indirect left recursion so the
+// grammar can be parsed top-down. A rule `A : A α | β` becomes
+//
+//	A      : β A_act A_tail       { $$ = $N }
+//	A_tail : α A_act2 A_tail      { $$ = $N }
+//	       |                      { $$ = $<field>0 }
+//
+// where the empty A_act rules run the original actions. Each action finds
+// the value built so far right before its α on the value stack, so values
+// still associate left to right.
+func (self *Grammar) EliminateLeftRecursion() error {
+	self.materializeDefaultCode()
+	order, groups := self.groupProds()
+	reach := self.leftReach(groups)
+	helpers := make(map[string][]string)
+
+	for i, ai := range order {
+		// indirect recursion: substitute the rules of earlier nonterminals
+		// that lead back to ai
+		for _, aj := range order[:i] {
+			if !reach[aj][ai] {
+				continue
+			}
+			expanded := make([]Production, 0)
+			for _, prod := range groups[ai] {
+				if len(prod.body) == 0 || prod.body[0] != aj {
+					expanded = append(expanded, prod)
+					continue
+				}
+				for _, sub := range groups[aj] {
+					act := self.newNonterm(ai+"_act", self.nontermTypes[aj])
+					helpers[ai] = append(helpers[ai], act)
+					groups[act] = []Production{self.movedAction(act, &sub, -len(sub.body))}
+
+					body := append(append(append([]string{}, sub.body...), act), prod.body[1:]...)
+					code := self.moveActionRefs(prod.code, prod.body, func(n int) int {
+						if n >= 1 {
+							return n + len(sub.body)
+						}
+						return n
+					})
+					expanded = append(expanded, Production{name: ai, body: body, code: code,
+						pos: prod.pos, codePos: prod.codePos, prec: prod.prec})
+				}
+			}
+			groups[ai] = expanded
+		}
+
+		recursive := false
+		for _, prod := range groups[ai] {
+			if len(prod.body) > 0 && prod.body[0] == ai {
+				recursive = true
+				if len(prod.body) == 1 {
+					return &GrammarError{Position: prod.pos, Msg: fmt.Sprintf("rule %s is cyclic", prod)}
+				}
+			}
+		}
+		if !recursive {
+			continue
+		}
+		tail, err := self.eliminateDirect(ai, groups, helpers)
+		if err != nil {
+			return err
+		}
+		helpers[ai] = append(helpers[ai], tail)
+	}
+
+	self.prods = make([]Production, 0)
+	for _, name := range order {
+		self.prods = append(self.prods, groups[name]...)
+		for _, helper := range helpers[name] {
+			self.prods = append(self.prods, groups[helper]...)
+		}
+	}
+	return self.checkLeftRecursion()
+}
+
+// eliminateDirect removes the direct left recursion of nonterminal a and
+// returns the name of the tail nonterminal it introduced.
+func (self *Grammar) eliminateDirect(a string, groups map[string][]Production, helpers map[string][]string) (string, error) {
+	field := self.nontermTypes[a]
+	tail := self.newNonterm(a+"_tail", field)
+	rules := make([]Production, 0)
+	tails := make([]Production, 0)
+
+	for _, prod := range groups[a] {
+		if len(prod.body) > 0 && prod.body[0] == a {
+			// A : A α  ->  A_tail : α A_act A_tail
+			alpha := prod.body[1:]
+			for _, ref := range findActionRefs(prod.code) {
+				if !ref.lhs && ref.index <= 0 {
+					return "", &GrammarError{Position: prod.codePos,
+						Msg: fmt.Sprintf("%s cannot be kept when removing the left recursion of %s", ref, a)}
+				}
+			}
+			body := append([]string{}, alpha...)
+			if len(prod.code) > 0 || len(field) > 0 {
+				act := self.newNonterm(a+"_act", field)
+				helpers[a] = append(helpers[a], act)
+				groups[act] = []Production{self.movedAction(act, &prod, -len(prod.body))}
+				body = append(body, act)
+			}
+			body = append(body, tail)
+			tails = append(tails, Production{name: tail, body: body,
+				code: copyValue(field, len(body)), pos: prod.pos,
+				prec: prod.prec, aliases: keptAliases(&prod, 1, body)})
+		} else {
+			// A : β  ->  A : β A_act A_tail
+			body := append([]string{}, prod.body...)
+			if len(prod.code) > 0 || len(field) > 0 {
+				act := self.newNonterm(a+"_act", field)
+				helpers[a] = append(helpers[a], act)
+				groups[act] = []Production{self.movedAction(act, &prod, -len(prod.body))}
+				body = append(body, act)
+			}
+			body = append(body, tail)
+			rules = append(rules, Production{name: a, body: body,
+				code: copyValue(field, len(body)), pos: prod.pos,
+				prec: prod.prec, aliases: keptAliases(&prod, 0, body)})
+		}
+	}
+	if len(rules) == 0 {
+		return "", &GrammarError{Position: groups[a][0].pos,
+			Msg: fmt.Sprintf("rule %s has no alternative without left recursion", a)}
+	}
+	// the last tail hands over the value built so far
+	end := Production{name: tail, body: []string{}, pos: tails[len(tails)-1].pos}
+	if len(field) > 0 {
+		end.code = fmt.Sprintf("{ $$ = $<%s>0 }", field)
+	}
+	groups[a] = rules
+	groups[tail] = append(tails, end)
+	return tail, nil
+}
+
+// keptAliases returns the aliases of body, which starts with the symbols of
+// prod from its from-th on; the symbols added after them have none.
+func keptAliases(prod *Production, from int, body []string) []string {
+	aliases := make([]string, len(body))
+	for i := range aliases {
+		if from+i < len(prod.aliases) && from+i < len(prod.body) {
+			aliases[i] = prod.aliases[from+i]
+		}
+	}
+	return aliases
+}
+
+// LeftFactor moves the prefix shared by alternatives into one rule and what
+// follows it into a new nonterminal, so `A : α β1 | α β2` becomes
+//
+//	A      : α A_rest    { $$ = $N }
+//	A_rest : β1 | β2
+//
+// The actions move along with β and reach the values of α below the rule,
+// so they keep the meaning of their original $N.
+func (self *Grammar) LeftFactor() {
+	self.materializeDefaultCode()
+	order, groups := self.groupProds()
+	self.prods = make([]Production, 0)
+	for _, name := range order {
+		self.prods = append(self.prods, self.leftFactor(name, name, groups[name])...)
+	}
+}
+
+// leftFactor factors the rules of nonterminal name and returns them followed
+// by the rules of the nonterminals it introduced, which are named after base.
+func (self *Grammar) leftFactor(name, base string, prods []Production) []Production {
+	field := self.nontermTypes[name]
+	factored := make([]Production, 0)
+	helpers := make([]Production, 0)
+	done := make(map[int]bool)
+	for i, prod := range prods {
+		if done[i] {
+			continue
+		}
+		group := []int{i}
+		for j := i + 1; j < len(prods) && len(prod.body) > 0; j++ {
+			if !done[j] && len(prods[j].body) > 0 && prods[j].body[0] == prod.body[0] {
+				group = append(group, j)
+			}
+		}
+		if len(group) == 1 {
+			factored = append(factored, prod)
+			continue
+		}
+		n := len(prod.body)
+		for _, j := range group[1:] {
+			k := 0
+			for k < n && k < len(prods[j].body) && prods[j].body[k] == prod.body[k] {
+				k++
+			}
+			n = k
+		}
+
+		// the factored rule binds as its alternatives if they all agree
+		prec := prod.prec
+		for _, j := range group[1:] {
+			if prods[j].prec != prec {
+				prec = ""
+			}
+		}
+		rest := self.newNonterm(base+"_rest", field)
+		body := append(append([]string{}, prod.body[:n]...), rest)
+		factored = append(factored, Production{name: name, body: body, prec: prec, aliases: keptAliases(&prod, 0, body),
+			code: copyValue(field, n+1), pos: prod.pos, spanBelow: prod.spanBelow})
+		restProds := make([]Production, 0)
+		for _, j := range group {
+			done[j] = true
+			alt := &prods[j]
+			code := self.moveActionRefs(alt.code, alt.body, func(k int) int {
+				return k - n
+			})
+			restBody := append([]string{}, alt.body[n:]...)
+			restProds = append(restProds, Production{name: rest, body: restBody, prec: alt.prec,
+				aliases: keptAliases(alt, n, restBody), code: code, pos: alt.pos, codePos: alt.codePos,
+				spanBelow: n + alt.spanBelow})
+		}
+		helpers = append(helpers, self.leftFactor(rest, base, restProds)...)
+	}
+	return append(factored, helpers...)
+}
+
+// movedAction builds the empty rule name running the action of prod, with
+// its references renumbered by offset. It spans the body of prod.
+func (self *Grammar) movedAction(name string, prod *Production, offset int) Production {
+	code := self.moveActionRefs(prod.code, prod.body, func(n int) int {
+		return n + offset
+	})
+	return Production{name: name, body: []string{}, code: code, pos: prod.pos, codePos: prod.codePos,
+		spanBelow: -offset}
+}
+
+// copyValue is the action passing the value of symbol n up to the rule.
+func copyValue(field string, n int) string {
+	if len(field) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("{ $$ = $%d }", n)
+}
+
+// materializeDefaultCode copies %defaultcode into the rules without an
+// action, so that rules made up by a transformation stay without one.
+func (self *Grammar) materializeDefaultCode() {
+	if len(self.defaultcode) == 0 {
+		return
+	}
+	for i := range self.prods {
+		if len(self.prods[i].code) == 0 {
+			self.prods[i].code = self.defaultcode
+			self.prods[i].codePos = self.defaultPos
+		}
+	}
+	self.defaultcode = ""
+}
+
+// groupProds returns the nonterminals in the order of their first rule and
+// their rules.
+func (self *Grammar) groupProds() ([]string, map[string][]Production) {
+	order := make([]string, 0)
+	groups := make(map[string][]Production)
+	for _, prod := range self.prods {
+		if _, b := groups[prod.name]; !b {
+			order = append(order, prod.name)
+		}
+		groups[prod.name] = append(groups[prod.name], prod)
+	}
+	return order, groups
+}
+
+// leftReach tells for every pair of nonterminals whether the first can
+// derive a sentential form starting with the second.
+func (self *Grammar) leftReach(groups map[string][]Production) map[string]map[string]bool {
+	reach := make(map[string]map[string]bool)
+	for name, prods := range groups {
+		reach[name] = make(map[string]bool)
+		for _, prod := range prods {
+			if len(prod.body) > 0 {
+				if _, b := groups[prod.body[0]]; b {
+					reach[name][prod.body[0]] = true
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, to := range reach {
+			for mid := range to {
+				for next := range reach[mid] {
+					if !to[next] {
+						to[next] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return reach
+}
+
+// checkLeftRecursion reports left recursion the rewrite cannot remove,
+// which hides behind symbols deriving the empty string.
+func (self *Grammar) checkLeftRecursion() error {
+	nullable := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, prod := range self.prods {
+			if nullable[prod.name] {
+				continue
+			}
+			empty := true
+			for _, sym := range prod.body {
+				empty = empty && nullable[sym]
+			}
+			if empty {
+				nullable[prod.name] = true
+				changed = true
+			}
+		}
+	}
+	order, groups := self.groupProds()
+	for _, start := range order {
+		visited := make(map[string]bool)
+		var visit func(name string) *Production
+		visit = func(name string) *Production {
+			for i := range groups[name] {
+				prod := &groups[name][i]
+				for _, sym := range prod.body {
+					if sym == start {
+						return prod
+					}
+					if _, b := self.symbolSet[sym]; b && !visited[sym] {
+						visited[sym] = true
+						if found := visit(sym); found != nil {
+							return found
+						}
+					}
+					if !nullable[sym] {
+						break
+					}
+				}
+			}
+			return nil
+		}
+		if prod := visit(start); prod != nil {
+			return &GrammarError{Position: prod.pos,
+				Msg: fmt.Sprintf("left recursion of %s through empty symbols in rule %s", start, prod)}
+		}
+	}
+	return nil
+}
+
+// newNonterm registers a nonterminal made up by a transformation, named
+// after prefix, with the given union field.
+func (self *Grammar) newNonterm(prefix string, field string) string {
+	name := prefix
+	for i := 2; ; i++ {
+		if _, b := self.symbolSet[name]; !b {
+			break
+		}
+		name = fmt.Sprintf("%s%d", prefix, i)
+	}
+	self.symbolSet[name] = len(self.symbolSet)
+	if len(field) > 0 {
+		self.nontermTypes[name] = field
+	}
+	return name
+}
+
+// DumpGrammar writes the rules the tables are built from, in grammar file
+// syntax, with the %type of every nonterminal and the precedences.
+func (self *Grammar) DumpGrammar(w io.Writer) error {
+	order, groups := self.groupProds()
+	fields := make(map[string][]string)
+	for _, name := range order {
+		if field, b := self.nontermTypes[name]; b {
+			fields[field] = append(fields[field], name)
+		}
+	}
+	text := ""
+	for _, field := range sortedKeys(self.unionTypes) {
+		if len(fields[field]) > 0 {
+			text += fmt.Sprintf("%%type<%s> %s\n", field, strings.Join(fields[field], " "))
+		}
+	}
+	levels := make(map[int][]string)
+	for name, prec := range self.precs {
+		levels[prec.Level] = append(levels[prec.Level], name)
+	}
+	for level := 1; level <= len(levels); level++ {
+		names := levels[level]
+		sort.Strings(names)
+		assoc := []string{"%left", "%right", "%nonassoc"}[self.precs[names[0]].Assoc]
+		text += fmt.Sprintf("%s %s\n", assoc, strings.Join(names, " "))
+	}
+	if len(self.starts) > 0 {
+		text += fmt.Sprintf("%%start %s\n", strings.Join(self.starts, " "))
+	}
+	text += "\n%%\n"
+	for _, name := range order {
+		indent := strings.Repeat(" ", len(name))
+		for i, prod := range groups[name] {
+			if i == 0 {
+				text += "\n" + name + " :"
+			} else {
+				text += indent + " |"
+			}
+			for _, sym := range prod.body {
+				text += " " + sym
+			}
+			if len(prod.prec) > 0 {
+				text += " %prec " + prod.prec
+			}
+			if len(prod.code) > 0 {
+				text += "    " + prod.code
+			}
+			text += "\n"
+		}
+		text += indent + " ;\n"
+	}
+	_, err := io.WriteString(w, text)
+	return err
+}
@@ -0,0 +1,391 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const leftRecursive = `
+%union {
+    v string
+}
+%token<v> id
+%type<v> E T
+%%
+E : E '+' T      { $$ = add($1,$3) }
+  | E '-' T      { $$ = sub($1,$3) }
+  | T            { $$ = $1 }
+  ;
+T : T '*' id     { $$ = mul($1,$3) }
+  | id
+  ;
+`
+
+func TestEliminateLeftRecursion(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%defaultcode { $$ = $1 }"+leftRecursive)
+	if err := grammar.EliminateLeftRecursion(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"id":                      "id",
+		"id '+' id":               "add(id,id)",
+		"id '+' id '-' id":        "sub(add(id,id),id)",
+		"id '*' id '*' id":        "mul(mul(id,id),id)",
+		"id '+' id '*' id '-' id": "sub(add(id,mul(id,id)),id)",
+	}
+	for input, expected := range cases {
+		if value := evalLL(t, grammar, input); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, value)
+		}
+	}
+}
+
+func TestEliminateIndirectLeftRecursion(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `
+%union {
+    v string
+}
+%token<v> id
+%type<v> X L P
+%%
+X : L '.'          { $$ = $1 }
+  ;
+L : P              { $$ = list($1) }
+  ;
+P : L ',' id       { $$ = pair($1,$3) }
+  | id             { $$ = $1 }
+  ;
+`)
+	if err := grammar.EliminateLeftRecursion(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"id '.'":               "list(id)",
+		"id ',' id '.'":        "list(pair(list(id),id))",
+		"id ',' id ',' id '.'": "list(pair(list(pair(list(id),id)),id))",
+	}
+	for input, expected := range cases {
+		if value := evalLL(t, grammar, input); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, value)
+		}
+	}
+}
+
+func TestEliminateLeftRecursionErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		content string
+		err     string
+	}{
+		{"A : A\n  | x\n  ;", "2:3: rule A : A is cyclic"},
+		{"A : A 'x'\n  | A 'y'\n  ;", "2:3: rule A has no alternative without left recursion"},
+		{"A : A x { $$ = $0 }\n  | x\n  ;", "2:9: $0 cannot be kept when removing the left recursion of A"},
+		{"A : B A x\n  | y\n  ;\nB : 'b'\n  |\n  ;", "2:3: left recursion of A through empty symbols in rule A : B A x"},
+	}
+	for _, c := range cases {
+		grammar := parseTestGrammar(t, "%%\n"+c.content)
+		err := grammar.EliminateLeftRecursion()
+		if err == nil {
+			t.Errorf("Expected error %q, got none", c.err)
+		} else if err.Error() != c.err {
+			t.Errorf("Expected error %q, got %q", c.err, err.Error())
+		}
+	}
+}
+
+func TestEliminateLeftRecursionPrec(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%left '-'
+%right uminus
+%%
+E : E '-' E %prec uminus
+  | '-'[neg] E %prec uminus
+  | id
+  ;`)
+	if err := grammar.EliminateLeftRecursion(); err != nil {
+		t.Fatal(err)
+	}
+	precs := make([]string, 0)
+	for _, prod := range grammar.prods {
+		precs = append(precs, fmt.Sprintf("%s %%prec %q %v", prod, prod.prec, prod.aliases))
+	}
+	expected := []string{
+		`E : '-' E E_tail %prec "uminus" [neg  ]`,
+		`E : id E_tail %prec "" [ ]`,
+		`E_tail : '-' E E_tail %prec "uminus" [  ]`,
+		`E_tail : /* empty */ %prec "" []`,
+	}
+	if strings.Join(precs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(precs, "\n"))
+	}
+}
+
+func TestDumpGrammar(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, leftRecursive)
+	if err := grammar.EliminateLeftRecursion(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := grammar.DumpGrammar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `%type<v> E E_act E_act2 E_act3 E_tail T T_act T_act2 T_tail
+
+%%
+
+E : T E_act3 E_tail    { $$ = $3 }
+  ;
+
+E_act :    { $$ = add($<v>-2,$<v>0) }
+      ;
+
+E_act2 :    { $$ = sub($<v>-2,$<v>0) }
+       ;
+
+E_act3 :    { $$ = $<v>0 }
+       ;
+
+E_tail : '+' T E_act E_tail    { $$ = $4 }
+       | '-' T E_act2 E_tail    { $$ = $4 }
+       |    { $$ = $<v>0 }
+       ;
+
+T : id T_act2 T_tail    { $$ = $3 }
+  ;
+
+T_act :    { $$ = mul($<v>-2,$<v>0) }
+      ;
+
+T_act2 :
+       ;
+
+T_tail : '*' id T_act T_tail    { $$ = $4 }
+       |    { $$ = $<v>0 }
+       ;
+`
+	if buf.String() != expected {
+		t.Errorf("Expected dump:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestLLParserEliminateLeftRecursion(t *testing.T) {
+	var dump bytes.Buffer
+	generator := &Generator{EliminateLeftRecursion: true, GrammarDump: &dump}
+	grammar := "%package main\n" + leftRecursive + "%%\nfunc add(a, b string) string { return a + b }\n" +
+		"func sub(a, b string) string { return a + b }\nfunc mul(a, b string) string { return a + b }\n"
+	if _, err := generate(t, generator, grammar); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump.String(), "E_tail") {
+		t.Errorf("Expected the dumped grammar to use E_tail, got:\n%s", dump.String())
+	}
+	if _, err := generate(t, &Generator{}, grammar); err == nil {
+		t.Errorf("Expected conflicts without EliminateLeftRecursion")
+	}
+}
+
+const commonPrefixes = `
+%union {
+    v string
+}
+%token<v> id
+%type<v> S
+%%
+S : id '=' id          { $$ = assign($1,$3) }
+  | id '(' ')'         { $$ = call($1) }
+  | id '(' id ')'      { $$ = call($1,$3) }
+  | 'x'
+  ;
+`
+
+func TestLeftFactor(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%defaultcode { $$ = $1 }"+commonPrefixes)
+	grammar.LeftFactor()
+	cases := map[string]string{
+		"id '=' id":     "assign(id,id)",
+		"id '(' ')'":    "call(id)",
+		"id '(' id ')'": "call(id,id)",
+		"'x'":           "'x'",
+	}
+	for input, expected := range cases {
+		if value := evalLL(t, grammar, input); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, value)
+		}
+	}
+
+	var buf bytes.Buffer
+	grammar.DumpGrammar(&buf)
+	expected := `%type<v> S S_rest S_rest2
+
+%%
+
+S : id S_rest    { $$ = $2 }
+  | 'x'    { $$ = $1 }
+  ;
+
+S_rest : '=' id    { $$ = assign($<v>0,$2) }
+       | '(' S_rest2    { $$ = $2 }
+       ;
+
+S_rest2 : ')'    { $$ = call($<v>-1) }
+        | id ')'    { $$ = call($<v>-1,$1) }
+        ;
+`
+	if buf.String() != expected {
+		t.Errorf("Expected dump:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestLeftFactorDuplicates(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, "%%\nA : x y\n  | x y\n  | x\n  ;")
+	grammar.LeftFactor()
+	var buf bytes.Buffer
+	grammar.DumpGrammar(&buf)
+	expected := `
+%%
+
+A : x A_rest
+  ;
+
+A_rest : y A_rest2
+       |
+       ;
+
+A_rest2 :
+        |
+        ;
+`
+	if buf.String() != expected {
+		t.Errorf("Expected dump:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestLeftFactorPrec(t *testing.T) {
+	t.Parallel()
+	grammar := parseTestGrammar(t, `%left '+'
+%left '*'
+%%
+E : '-'[neg] E[arg] %prec '*'
+  | '-' E '+'[plus]
+  | '-' E '+' id %prec '+'
+  | id
+  ;`)
+	grammar.LeftFactor()
+	precs := make([]string, 0)
+	for _, prod := range grammar.prods {
+		precs = append(precs, fmt.Sprintf("%s %%prec %q %v", prod, prod.prec, prod.aliases))
+	}
+	expected := []string{
+		`E : '-' E E_rest %prec "" [neg arg ]`,
+		`E : id %prec "" []`,
+		`E_rest : /* empty */ %prec "'*'" []`,
+		`E_rest : '+' E_rest2 %prec "" [plus ]`,
+		`E_rest2 : /* empty */ %prec "" []`,
+		`E_rest2 : id %prec "'+'" []`,
+	}
+	if strings.Join(precs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(precs, "\n"))
+	}
+}
+
+func TestLLParserLeftFactor(t *testing.T) {
+	grammar := "%package main\n" + commonPrefixes + "%%\nfunc assign(a, b string) string { return a + b }\n" +
+		"func call(a string, b ...string) string { return a }\n"
+	if _, err := generate(t, &Generator{LeftFactor: true}, grammar); err != nil {
+		t.Fatal(err)
+	}
+	_, err := generate(t, &Generator{}, grammar)
+	if _, b := err.(*ConflictError); !b {
+		t.Errorf("Expected conflicts without LeftFactor, got %v", err)
+	}
+}
+
+func parseTestGrammar(t *testing.T, content string) *Grammar {
+	scanner := &Scanner{content: []byte(content), index: 0}
+	grammar := NewGrammar()
+	if err := grammar.ParseHeaders(scanner); err != nil {
+		t.Fatal(err)
+	}
+	if err := grammar.ParseGrammars(scanner); err != nil {
+		t.Fatal(err)
+	}
+	return grammar
+}
+
+// evalLL parses the space separated words of input with the LL table of
+// grammar and runs the actions with evalAction, a word being its own value.
+func evalLL(t *testing.T, grammar *Grammar, input string) string {
+	symbols := grammar.MergeSymbols()
+	firsts := ComputeFirsts(grammar.prods, symbols, grammar.maxToken)
+	follows := ComputeFollows(grammar.prods, symbols, firsts, grammar.maxToken)
+	lltable, conflicts := ComputeLLTable(grammar.prods, symbols, firsts, follows, grammar.maxToken+1, len(symbols)-1)
+	if len(conflicts) > 0 {
+		t.Fatalf("Unexpected conflicts: %v", conflicts)
+	}
+
+	words := strings.Fields(input)
+	values := make([]string, 0)
+	lookahead := func() int {
+		if len(words) == 0 {
+			return symbols["$"]
+		}
+		return symbols[words[0]]
+	}
+	var derive func(sym string) error
+	derive = func(sym string) error {
+		idx := symbols[sym]
+		if idx <= grammar.maxToken {
+			if idx != lookahead() {
+				return fmt.Errorf("expected %s, got %v", sym, words)
+			}
+			values = append(values, words[0])
+			words = words[1:]
+			return nil
+		}
+		p := lltable[idx][lookahead()]
+		if p < 0 {
+			return fmt.Errorf("no rule of %s for %v", sym, words)
+		}
+		prod := &grammar.prods[p]
+		for _, s := range prod.body {
+			if err := derive(s); err != nil {
+				return err
+			}
+		}
+		values = evalAction(grammar, prod, values)
+		return nil
+	}
+	if err := derive(grammar.prods[0].name); err != nil {
+		t.Fatalf("Parsing %s: %s", input, err)
+	}
+	if len(words) > 0 {
+		t.Fatalf("Parsing %s: trailing words %v", input, words)
+	}
+	return values[0]
+}
+
+// evalAction runs the action of prod on string values, the values of its
+// body being on top: an action `{ $$ = text }` yields text with every
+// reference replaced by the value it stands for.
+func evalAction(grammar *Grammar, prod *Production, values []string) []string {
+	code := prod.code
+	if len(code) == 0 {
+		code = grammar.defaultcode
+	}
+	below, rhs := values[:len(values)-len(prod.body)], values[len(values)-len(prod.body):]
+	expr := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(code), "{ $$ = "), " }")
+	value := rewriteActionRefs(expr, func(ref actionRef) string {
+		if ref.index >= 1 {
+			return rhs[ref.index-1]
+		}
+		return below[len(below)-1+ref.index]
+	})
+	return append(below, value)
+}
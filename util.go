@@ -2,6 +2,7 @@ package parser
 
 import (
 	"log"
+	"sort"
 )
 
 const Debug = true
@@ -34,6 +35,23 @@ func type2Str(ttype TokType) string {
 		return "separate"
 	case other:
 		return "other token"
+	case lparen:
+		return "lparen"
+	case rparen:
+		return "rparen"
+	case repeat:
+		return "repeat"
+	case pattern:
+		return "pattern"
 	}
 	return ""
 }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}